@@ -0,0 +1,210 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Leantar/fimagent/modules/watcher"
+	"github.com/rs/zerolog/log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const adminTimeout = 5 * time.Second
+
+type AdminConfig struct {
+	// Address is a loopback address of the HTTP listener serving the status at /status, e.g. 127.0.0.1:9470
+	Address string `yaml:"address"`
+	// Socket is the path of a unix socket serving the status. It is only accessible by the user running the agent
+	Socket string `yaml:"socket"`
+}
+
+// Status describes the health of the running agent.
+type Status struct {
+	Version string `json:"version"`
+	// Mode is either online or offline
+	Mode string `json:"mode"`
+	// Connection is the state of the connection to the server, e.g. READY or TRANSIENT_FAILURE
+	Connection string `json:"connection"`
+	Server     string `json:"server,omitempty"`
+	// LastContact is the time of the last successful RPC to the server
+	LastContact *time.Time `json:"last_contact,omitempty"`
+	// LastEvent is the time of the last file system event
+	LastEvent    *time.Time `json:"last_event,omitempty"`
+	StartedAt    time.Time  `json:"started_at"`
+	WatchedPaths []string   `json:"watched_paths"`
+	Backend      string     `json:"backend"`
+	// PendingEvents is the number of events waiting to be debounced and reported. The agent does not spool events
+	// while the server is unavailable, so this is the only backlog it has
+	PendingEvents int         `json:"pending_events"`
+	LastScan      *ScanResult `json:"last_scan,omitempty"`
+}
+
+// Status returns the current status of the agent.
+func (a *Agent) Status() Status {
+	a.mu.Lock()
+
+	s := Status{
+		Version:      Version,
+		Mode:         "online",
+		Connection:   "DISCONNECTED",
+		StartedAt:    a.startedAt,
+		WatchedPaths: a.watchedPaths,
+		Backend:      watcher.Backend,
+	}
+
+	if a.conf.Offline.Enabled {
+		s.Mode = "offline"
+		s.Connection = "OFFLINE"
+	} else {
+		s.Server = net.JoinHostPort(a.conf.Host, strconv.FormatInt(a.conf.Port, 10))
+	}
+	if a.conn != nil {
+		s.Connection = a.conn.GetState().String()
+	}
+	if !a.lastContact.IsZero() {
+		lastContact := a.lastContact
+		s.LastContact = &lastContact
+	}
+	if !a.lastEvent.IsZero() {
+		lastEvent := a.lastEvent
+		s.LastEvent = &lastEvent
+	}
+	if !a.lastScan.StartedAt.IsZero() {
+		lastScan := a.lastScan
+		s.LastScan = &lastScan
+	}
+	w := a.watcher
+
+	a.mu.Unlock()
+
+	// The watcher blocks while the agent handles an event, which may need the lock of the agent
+	if w != nil {
+		s.PendingEvents = w.Pending()
+	}
+
+	return s
+}
+
+// eventReceived records the time of the last file system event.
+func (a *Agent) eventReceived() {
+	a.mu.Lock()
+	a.lastEvent = time.Now()
+	a.mu.Unlock()
+}
+
+// serveAdmin serves the status on the configured address and socket until the listeners fail.
+func (a *Agent) serveAdmin() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(a.Status())
+	})
+
+	listeners, err := adminListeners(a.conf.Admin)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to serve admin endpoint")
+		return
+	}
+
+	for _, l := range listeners {
+		log.Info().Msgf("serving status on %s", l.Addr())
+
+		go func(l net.Listener) {
+			err := http.Serve(l, mux)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to serve admin endpoint")
+			}
+		}(l)
+	}
+}
+
+// adminListeners listens on the configured address and socket. The address must be a loopback address,
+// because the status is served without authentication.
+func adminListeners(conf AdminConfig) ([]net.Listener, error) {
+	var listeners []net.Listener
+
+	if conf.Address != "" {
+		host, _, err := net.SplitHostPort(conf.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		ip := net.ParseIP(host)
+		if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return nil, fmt.Errorf("admin address %s is not a loopback address", conf.Address)
+		}
+
+		l, err := net.Listen("tcp", conf.Address)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+
+	if conf.Socket != "" {
+		// Remove the socket left behind by a previous run
+		err := os.Remove(conf.Socket)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		l, err := net.Listen("unix", conf.Socket)
+		if err != nil {
+			return nil, err
+		}
+
+		err = os.Chmod(conf.Socket, 0600)
+		if err != nil {
+			_ = l.Close()
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+// QueryStatus requests the status of a running agent from its admin endpoint. The socket is preferred if both are configured.
+func QueryStatus(conf AdminConfig) (Status, error) {
+	var status Status
+
+	client := http.Client{Timeout: adminTimeout}
+	url := "http://" + conf.Address + "/status"
+
+	switch {
+	case conf.Socket != "":
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", conf.Socket)
+			},
+		}
+		url = "http://fimagent/status"
+	case conf.Address == "":
+		return status, errors.New("no admin address or socket configured")
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return status, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("admin endpoint returned %s", resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&status)
+
+	return status, err
+}
@@ -7,38 +7,127 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Leantar/fimagent/models"
+	"github.com/Leantar/fimagent/modules/analyzer"
+	"github.com/Leantar/fimagent/modules/baseline"
+	"github.com/Leantar/fimagent/modules/maintenance"
+	"github.com/Leantar/fimagent/modules/pkgdb"
+	"github.com/Leantar/fimagent/modules/privileges"
+	"github.com/Leantar/fimagent/modules/sink"
+	"github.com/Leantar/fimagent/modules/systemd"
+	"github.com/Leantar/fimagent/modules/throttle"
+	"github.com/Leantar/fimagent/modules/walker"
 	"github.com/Leantar/fimagent/modules/watcher"
 	"github.com/Leantar/fimproto/proto"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
-	"io/fs"
 	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 type Config struct {
-	Host        string `yaml:"host"`
-	Port        int64  `yaml:"port"`
-	CertFile    string `yaml:"cert_file"`
-	CertKeyFile string `yaml:"cert_key_file"`
-	CaFile      string `yaml:"ca_file"`
+	// ConfigFile is the path the config was loaded from. It is protected like the executable and TLS files of the agent
+	ConfigFile  string          `yaml:"-"`
+	Host        string          `yaml:"host"`
+	Port        int64           `yaml:"port"`
+	CertFile    string          `yaml:"cert_file"`
+	CertKeyFile string          `yaml:"cert_key_file"`
+	CaFile      string          `yaml:"ca_file"`
+	Status      StatusConfig    `yaml:"status"`
+	Offline     OfflineConfig   `yaml:"offline"`
+	Throttle    throttle.Config `yaml:"throttle"`
+	Read        ReadConfig      `yaml:"read"`
+	// Traversal controls how watched paths are walked during scans. The policy with the longest matching path is used.
+	// A full status is not reported if a limit is reached, because the server would consider the remaining objects deleted
+	Traversal []walker.Policy `yaml:"traversal"`
+	// HashAlgorithms are computed for every regular file. The first one is reported to the server
+	HashAlgorithms []models.HashAlgorithm `yaml:"hash_algorithms"`
+	// HashPolicies control how large files are hashed. The policy with the longest matching path is used
+	HashPolicies []models.HashPolicy `yaml:"hash_policies"`
+	// DetectTimestomping compares consecutive states of objects to find suspicious timestamp changes
+	DetectTimestomping bool `yaml:"detect_timestomping"`
+	// VerifyPackages compares changed files against the digests recorded by dpkg and rpm
+	VerifyPackages bool `yaml:"verify_packages"`
+	// VerifyPackagesRehash hashes files again if they were hashed with another algorithm or only partially.
+	// Large files are read completely while events are handled
+	VerifyPackagesRehash bool `yaml:"verify_packages_rehash"`
+	// Maintenance declares maintenance windows and detects package manager runs, whose changes are tagged or batched
+	Maintenance maintenance.Config `yaml:"maintenance"`
+	// Sinks receive a copy of every event reported to the server or written as alert
+	Sinks   []sink.Config `yaml:"sinks"`
+	Metrics MetricsConfig `yaml:"metrics"`
+	Admin   AdminConfig   `yaml:"admin"`
+	// Privileges are dropped once all watches are in place. Files written by the agent must be writable by the configured user
+	Privileges privileges.Config `yaml:"privileges"`
+}
+
+// ReadConfig controls how files are read for each scan type.
+type ReadConfig struct {
+	// Scan is used for full scans of all watched paths
+	Scan models.ReadOptions `yaml:"scan"`
+	// Event is used for single objects after a file system event
+	Event models.ReadOptions `yaml:"event"`
+}
+
+type ScanResult struct {
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration"`
+	Objects   int           `json:"objects"`
+	// Truncated contains the watched paths whose scan reached a limit of their traversal policy
+	Truncated []string `json:"truncated,omitempty"`
 }
 
 type Agent struct {
-	conn   *grpc.ClientConn
-	client proto.FimClient
-	conf   Config
+	conn     *grpc.ClientConn
+	client   proto.FimClient
+	conf     Config
+	state    *baseline.Baseline
+	stateKey []byte
+	limiter  *throttle.Limiter
+	analyzer *analyzer.Analyzer
+	packages *pkgdb.DB
+	sinks    sink.Multi
+	lastScan ScanResult
+	mu       *sync.Mutex
+
+	maintenance *maintenance.Monitor
+	changeSet   *changeSet
+	// packagesLoaded is the time the package database was loaded
+	packagesLoaded time.Time
+	// alerts is the alert file of offline mode
+	alerts sink.Multi
+
+	startedAt    time.Time
+	watchedPaths []string
+	watcher      *watcher.DebouncedWatcher
+	lastContact  time.Time
+	lastEvent    time.Time
+	stopped      chan struct{}
+	// protected contains the state of the agent's own files at startup
+	protected map[string]models.FsObject
 }
 
 func New(config Config) *Agent {
-	return &Agent{
-		conf: config,
+	a := Agent{
+		conf:        config,
+		limiter:     throttle.New(config.Throttle),
+		mu:          &sync.Mutex{},
+		maintenance: maintenance.New(config.Maintenance),
+		startedAt:   time.Now(),
+		stopped:     make(chan struct{}),
+	}
+
+	if config.DetectTimestomping {
+		a.analyzer = analyzer.New()
 	}
+
+	return &a
 }
 
 func (a *Agent) Connect() error {
@@ -49,18 +138,49 @@ func (a *Agent) Connect() error {
 
 	address := net.JoinHostPort(a.conf.Host, strconv.FormatInt(a.conf.Port, 10))
 
-	a.conn, err = grpc.Dial(address, grpc.WithTransportCredentials(creds))
+	a.conn, err = grpc.Dial(address,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUnaryInterceptor(a.unaryMetricsInterceptor),
+		grpc.WithStreamInterceptor(a.streamMetricsInterceptor),
+	)
 	if err != nil {
 		return err
 	}
 	log.Info().Msgf("connected to %s", address)
 
+	go a.watchConnection()
+
 	a.client = proto.NewFimClient(a.conn)
 
 	return nil
 }
 
 func (a *Agent) Run() error {
+	if a.conf.Metrics.Address != "" {
+		go a.serveMetrics()
+	}
+	if a.conf.Admin.Address != "" || a.conf.Admin.Socket != "" {
+		go a.serveAdmin()
+	}
+
+	// Privileges are dropped after the initial scan, which must not be wasted on a configuration that fails then
+	if a.conf.Privileges.Enabled() {
+		if err := privileges.Check(a.conf.Privileges); err != nil {
+			return fmt.Errorf("can not drop privileges: %w", err)
+		}
+	}
+
+	err := a.openSinks()
+	if err != nil {
+		return err
+	}
+
+	a.protectSelf()
+
+	if a.conf.Offline.Enabled {
+		return a.runOffline()
+	}
+
 	ctx := context.Background()
 	info, err := a.client.GetStartupInfo(ctx, &proto.Empty{})
 	if err != nil {
@@ -83,94 +203,255 @@ func (a *Agent) Run() error {
 
 func (a *Agent) Stop() error {
 	log.Info().Msg("stopping agent")
+	notify(systemd.Stopping)
+	close(a.stopped)
+	a.flushChangeSet()
+	a.reportStopping()
+
+	err := a.saveState()
+	if err != nil {
+		log.Error().Caller().Err(err).Msg("failed to save state")
+	}
+
+	err = a.closeSinks()
+	if err != nil {
+		log.Error().Caller().Err(err).Msg("failed to close sinks")
+	}
+
+	if a.conn == nil {
+		return nil
+	}
+
 	return a.conn.Close()
 }
 
 func (a *Agent) watchFsEvents(watchedPaths []string) error {
-	w := watcher.NewDebounced()
-
-	for _, path := range watchedPaths {
-		err := w.AddRecursiveWatch(path)
-		if err != nil {
-			return err
-		}
+	w, err := a.watch(watchedPaths)
+	if err != nil {
+		return err
 	}
+	notifyReady(watchedPaths)
 
-	ctx := context.Background()
-	for event := range w.Events {
-		var err error
-		var obj models.FsObject
+	ticker := time.NewTicker(changeSetInterval)
+	defer ticker.Stop()
+
+	ping, stopPing := watchdog()
+	defer stopPing()
 
-		if event.Kind() == watcher.KindDelete {
-			obj = models.FsObject{
-				Path: event.Path,
+	ctx := context.Background()
+	for {
+		select {
+		case event := <-w.Events:
+			err := a.reportFsEvent(ctx, event)
+			if err != nil {
+				return err
 			}
-		} else {
-			obj, err = models.NewFsObject(event.Path)
+		case <-ticker.C:
+			err := a.reportChangeSet(ctx, a.completedChangeSet())
 			if err != nil {
-				log.Warn().Caller().Err(err).Msg("failed to create new models")
-				continue
+				return err
 			}
+		case <-ping:
+			notify(systemd.Watchdog)
 		}
+	}
+}
 
-		evt := &proto.Event{
-			Kind:     event.Kind(),
-			IssuedAt: time.Now().Unix(),
-			FsObject: &proto.FsObject{
-				Path:     obj.Path,
-				Hash:     obj.Hash,
-				Created:  obj.Created,
-				Modified: obj.Modified,
-				Uid:      obj.Uid,
-				Gid:      obj.Gid,
-				Mode:     obj.Mode,
-			},
+func (a *Agent) reportFsEvent(ctx context.Context, event watcher.Event) error {
+	a.eventReceived()
+
+	if e, ok := a.checkTamper(event); ok {
+		if err := a.writeEvent(e); err != nil {
+			log.Warn().Err(err).Msg("failed to write tampering to sinks")
 		}
+	}
 
-		_, err = a.client.ReportFsEvent(ctx, evt)
-		if err != nil {
-			return err
+	if a.onlyProtected(event.Path) {
+		return nil
+	}
+
+	var obj models.FsObject
+	var findings []analyzer.Finding
+
+	if event.Kind() == watcher.KindDelete {
+		obj = models.FsObject{
+			Path: event.Path,
 		}
+		a.analyzer.Forget(event.Path)
+	} else {
+		var err error
+		obj, err = models.NewFsObject(event.Path, a.objectOptions())
+		if errors.Is(err, models.ErrModifiedWhileHashing) {
+			// Files which are constantly written to would never be reported otherwise
+			log.Warn().Err(err).Msg("reporting file without hash")
+		} else if err != nil {
+			log.Warn().Caller().Err(err).Msg("failed to create new models")
+			return nil
+		}
+		findings = a.analyze(obj)
+	}
+	verdict := a.verifyPackage(&obj)
+
+	c := baseline.Change{Kind: event.Kind(), New: obj}
+	reason, deferred := a.deferToChangeSet(c, event)
+	if deferred {
+		return nil
+	}
+
+	e := newEvent(c, findings, verdict)
+	e.Maintenance = reason
+	e.Pid = event.Pid
+	e.Processes = event.Processes
+
+	err := a.reportEvent(ctx, e)
+	if err != nil {
+		return err
 	}
 
+	a.updateState(c)
+
 	return nil
 }
 
-func (a *Agent) collectFsObjects(watchedPaths []string) ([]models.FsObject, error) {
+// watch starts watching all watchedPaths recursively and drops privileges afterwards.
+func (a *Agent) watch(watchedPaths []string) (*watcher.DebouncedWatcher, error) {
+	w := watcher.NewDebounced()
+
+	for _, path := range walker.Roots(watchedPaths) {
+		err := w.AddRecursiveWatch(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	a.watchProtected(w, watchedPaths)
+
+	// fanotify needs root only to create the marks. Refuse to run with more privileges than configured
+	if a.conf.Privileges.Enabled() {
+		err := privileges.Drop(a.conf.Privileges)
+		if err != nil {
+			return nil, fmt.Errorf("failed to drop privileges: %w", err)
+		}
+		log.Info().Msgf("dropped privileges to user %q", a.conf.Privileges.User)
+	}
+
+	a.mu.Lock()
+	a.watchedPaths = watchedPaths
+	a.watcher = w
+	a.mu.Unlock()
+
+	return w, nil
+}
+
+// CollectFsObjects walks all watched paths and returns the current state of every object below them.
+// Paths whose scan reached a limit of their traversal policy are recorded in the result returned by LastScan.
+func (a *Agent) CollectFsObjects(watchedPaths []string) (objs []models.FsObject, err error) {
+	start := time.Now()
+	var truncated []string
+
+	notify(systemd.Status(fmt.Sprintf("Scanning %d paths", len(watchedPaths))))
+
+	done := make(chan struct{})
+	go notifyScanProgress(start, len(watchedPaths), done)
+
+	// Scans run on a separate thread to be able to lower its priority
+	a.limiter.Run(func() {
+		objs, truncated, err = a.collectFsObjects(watchedPaths)
+	})
+	close(done)
+	if err != nil {
+		return
+	}
+
+	a.tagPackages(objs)
+
+	a.mu.Lock()
+	a.lastScan = ScanResult{
+		StartedAt: start,
+		Duration:  time.Since(start),
+		Objects:   len(objs),
+		Truncated: truncated,
+	}
+	a.mu.Unlock()
+
+	// Sinks learn about incomplete scans before the status is reported
+	a.writeTruncatedScans(truncated)
+
+	scanDuration.Set(time.Since(start).Seconds())
+	scanObjects.Set(float64(len(objs)))
+	scansTotal.Inc()
+	notify(systemd.Status(fmt.Sprintf("Scanned %d objects in %s", len(objs), time.Since(start).Round(time.Second))))
+
+	a.analyzer.Seed(objs)
+
+	return
+}
+
+// LastScan returns the result of the last completed scan.
+func (a *Agent) LastScan() ScanResult {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.lastScan
+}
+
+func (a *Agent) collectFsObjects(watchedPaths []string) ([]models.FsObject, []string, error) {
 	var objs []models.FsObject
+	var truncated []string
 
-	for _, path := range watchedPaths {
+	for _, path := range walker.Roots(watchedPaths) {
 		path = filepath.Clean(path)
 
-		stat, err := os.Lstat(path)
+		_, err := os.Lstat(path)
 		if errors.Is(err, os.ErrNotExist) {
 			// File/Folder does not exist. Server will generate "DELETE" alert
 			continue
 		}
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		if stat.IsDir() {
-			err = filepath.WalkDir(path, walk(&objs))
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			obj, err := models.NewFsObject(path)
-			if err != nil {
-				return nil, err
-			}
+		err = walker.Walk(path, walker.PolicyFor(a.conf.Traversal, path), walk(&objs, a.scanOptions()))
+		if errors.Is(err, walker.ErrTruncated) {
+			log.Warn().Err(err).Msgf("scan of %s was truncated", path)
+			truncated = append(truncated, path)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return objs, truncated, nil
+}
 
-			objs = append(objs, obj)
+// inTruncatedScan reports whether path is located in a watched path whose last scan was truncated.
+func (a *Agent) inTruncatedScan(path string) bool {
+	for _, t := range a.LastScan().Truncated {
+		if walker.IsBelow(path, t) {
+			return true
 		}
 	}
 
-	return objs, nil
+	return false
+}
+
+// writeTruncatedScans tells the sinks about watched paths which were not scanned completely.
+func (a *Agent) writeTruncatedScans(truncated []string) {
+	for _, path := range truncated {
+		e := sink.Event{
+			Kind:     sink.KindScanTruncated,
+			IssuedAt: time.Now().Unix(),
+			FsObject: models.FsObject{Path: path},
+		}
+
+		if err := a.writeEvent(e); err != nil {
+			log.Warn().Err(err).Msg("failed to write truncated scan to sinks")
+		}
+	}
 }
 
 func (a *Agent) createBaseline(watchedPaths []string) (err error) {
-	objs, err := a.collectFsObjects(watchedPaths)
+	objs, err := a.CollectFsObjects(watchedPaths)
 	if err != nil {
 		return
 	}
@@ -181,25 +462,21 @@ func (a *Agent) createBaseline(watchedPaths []string) (err error) {
 	}
 
 	for _, obj := range objs {
-		file := proto.FsObject{
-			Path:     obj.Path,
-			Hash:     obj.Hash,
-			Created:  obj.Created,
-			Modified: obj.Modified,
-			Uid:      obj.Uid,
-			Gid:      obj.Gid,
-			Mode:     obj.Mode,
-		}
-		if err = stream.Send(&file); err != nil {
+		a.writeObject(obj)
+		if err = stream.Send(newProtoFsObject(obj)); err != nil {
 			return
 		}
 	}
 	_, err = stream.CloseAndRecv()
-	return
+	if err != nil {
+		return
+	}
+
+	return a.resetState(watchedPaths, objs)
 }
 
 func (a *Agent) updateBaseline(watchedPaths []string) (err error) {
-	objs, err := a.collectFsObjects(watchedPaths)
+	objs, err := a.CollectFsObjects(watchedPaths)
 	if err != nil {
 		return
 	}
@@ -210,60 +487,106 @@ func (a *Agent) updateBaseline(watchedPaths []string) (err error) {
 	}
 
 	for _, obj := range objs {
-		file := proto.FsObject{
-			Path:     obj.Path,
-			Hash:     obj.Hash,
-			Created:  obj.Created,
-			Modified: obj.Modified,
-			Uid:      obj.Uid,
-			Gid:      obj.Gid,
-			Mode:     obj.Mode,
-		}
-		if err = stream.Send(&file); err != nil {
+		a.writeObject(obj)
+		if err = stream.Send(newProtoFsObject(obj)); err != nil {
 			return
 		}
 	}
 	_, err = stream.CloseAndRecv()
-	return
+	if err != nil {
+		return
+	}
+
+	return a.resetState(watchedPaths, objs)
 }
 
 func (a *Agent) reportFsStatus(watchedPaths []string) (err error) {
-	objs, err := a.collectFsObjects(watchedPaths)
+	objs, err := a.CollectFsObjects(watchedPaths)
 	if err != nil {
 		return
 	}
 
+	if a.conf.Status.Incremental {
+		err = a.loadState(watchedPaths)
+		if err == nil {
+			return a.reportFsChanges(watchedPaths, objs)
+		}
+		log.Warn().Err(err).Msg("failed to load last reported state. Reporting full status")
+	}
+
+	// The server reports every object missing from a full status as deleted
+	if truncated := a.LastScan().Truncated; len(truncated) > 0 {
+		err = fmt.Errorf("refusing to report an incomplete full status, scan of %s was truncated", strings.Join(truncated, ", "))
+		return
+	}
+
 	stream, err := a.client.ReportFsStatus(context.Background())
 	if err != nil {
 		return
 	}
 
 	for _, obj := range objs {
-		file := proto.FsObject{
-			Path:     obj.Path,
-			Hash:     obj.Hash,
-			Created:  obj.Created,
-			Modified: obj.Modified,
-			Uid:      obj.Uid,
-			Gid:      obj.Gid,
-			Mode:     obj.Mode,
-		}
-		if err = stream.Send(&file); err != nil {
+		a.writeObject(obj)
+		if err = stream.Send(newProtoFsObject(obj)); err != nil {
 			return
 		}
 	}
 
-	return stream.CloseSend()
+	// Wait for the response, so the state is only saved once the server accepted the status
+	_, err = stream.CloseAndRecv()
+	if err != nil {
+		return
+	}
+
+	return a.resetState(watchedPaths, objs)
 }
 
-func walk(objs *[]models.FsObject) fs.WalkDirFunc {
-	return func(path string, _ fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
+func newProtoFsObject(obj models.FsObject) *proto.FsObject {
+	return &proto.FsObject{
+		Path:     obj.Path,
+		Hash:     obj.ReportedHash(),
+		Created:  obj.Created,
+		Modified: obj.Modified,
+		Uid:      obj.Uid,
+		Gid:      obj.Gid,
+		Mode:     obj.Mode,
+	}
+}
 
-		obj, err := models.NewFsObject(path)
-		if err != nil {
+// analyze logs suspicious changes between the last known and the current state of obj.
+func (a *Agent) analyze(obj models.FsObject) []analyzer.Finding {
+	findings := a.analyzer.Analyze(obj)
+	for _, f := range findings {
+		log.Warn().Str("path", obj.Path).Str("finding", string(f)).Msg("detected suspicious metadata change")
+	}
+
+	return findings
+}
+
+func (a *Agent) objectOptions() models.Options {
+	return models.Options{
+		HashAlgorithms: a.conf.HashAlgorithms,
+		HashPolicies:   a.conf.HashPolicies,
+		Read:           a.conf.Read.Event,
+	}
+}
+
+// scanOptions returns the options for full scans, which unlike single events are throttled.
+func (a *Agent) scanOptions() models.Options {
+	opts := a.objectOptions()
+	opts.Read = a.conf.Read.Scan
+	opts.Throttler = a.limiter
+
+	return opts
+}
+
+func walk(objs *[]models.FsObject, opts models.Options) func(path string) error {
+	return func(path string) error {
+		obj, err := models.NewFsObject(path, opts)
+		if errors.Is(err, models.ErrModifiedWhileHashing) {
+			// Files which are constantly written to must not abort the scan. Leaving them out would report them as deleted
+			log.Warn().Err(err).Msg("reporting file without hash")
+		} else if err != nil {
 			return err
 		}
 
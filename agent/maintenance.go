@@ -0,0 +1,186 @@
+package agent
+
+import (
+	"context"
+	"github.com/Leantar/fimagent/models"
+	"github.com/Leantar/fimagent/modules/baseline"
+	"github.com/Leantar/fimagent/modules/sink"
+	"github.com/Leantar/fimagent/modules/watcher"
+	"github.com/rs/zerolog/log"
+	"sort"
+	"time"
+)
+
+// changeSetInterval is how often the end of maintenance is checked to report the collected changes
+const changeSetInterval = 5 * time.Second
+
+// changeSet collects the changes made during maintenance. Multiple changes of the same path are merged.
+type changeSet struct {
+	Reason  string
+	Start   time.Time
+	End     time.Time
+	changes map[string]baseline.Change
+}
+
+func newChangeSet(reason string) *changeSet {
+	return &changeSet{
+		Reason:  reason,
+		Start:   time.Now(),
+		changes: make(map[string]baseline.Change),
+	}
+}
+
+func (cs *changeSet) add(c baseline.Change) {
+	path := c.New.Path
+
+	old, ok := cs.changes[path]
+	if !ok {
+		cs.changes[path] = c
+		return
+	}
+
+	switch {
+	case old.Kind == watcher.KindCreate && c.Kind == watcher.KindDelete:
+		// The object only existed during maintenance
+		delete(cs.changes, path)
+	case old.Kind == watcher.KindCreate:
+		cs.changes[path] = baseline.Change{Kind: watcher.KindCreate, New: c.New}
+	case old.Kind == watcher.KindDelete && c.Kind == watcher.KindCreate:
+		// The object was replaced, e.g. by a package upgrade
+		cs.changes[path] = baseline.Change{Kind: watcher.KindChange, Old: old.Old, New: c.New}
+	default:
+		cs.changes[path] = baseline.Change{Kind: c.Kind, Old: old.Old, New: c.New}
+	}
+}
+
+// list returns all changes sorted by path.
+func (cs *changeSet) list() []baseline.Change {
+	changes := make([]baseline.Change, 0, len(cs.changes))
+	for _, c := range cs.changes {
+		changes = append(changes, c)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].New.Path < changes[j].New.Path
+	})
+
+	return changes
+}
+
+// deferToChangeSet returns the maintenance reason if c was made during maintenance. If batching is enabled,
+// c is added to the current change set and true is returned, so it is not reported on its own.
+func (a *Agent) deferToChangeSet(c baseline.Change, event watcher.Event) (string, bool) {
+	reason, ok := a.maintenance.Check(event.LastModified, event.Processes)
+	if !ok {
+		return "", false
+	}
+
+	log.Info().Str("path", c.New.Path).Str("maintenance", reason).Msg("change during maintenance")
+
+	if !a.conf.Maintenance.Batch {
+		return reason, false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.changeSet == nil {
+		a.changeSet = newChangeSet(reason)
+	}
+	a.changeSet.add(c)
+
+	return reason, true
+}
+
+// completedChangeSet returns the current change set once maintenance ended and starts a new one.
+func (a *Agent) completedChangeSet() *changeSet {
+	a.maintenance.Poll()
+	if _, ok := a.maintenance.Check(time.Now(), nil); ok {
+		return nil
+	}
+
+	return a.takeChangeSet()
+}
+
+// takeChangeSet returns the current change set regardless of whether maintenance ended and starts a new one.
+func (a *Agent) takeChangeSet() *changeSet {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	cs := a.changeSet
+	if cs == nil {
+		return nil
+	}
+
+	a.changeSet = nil
+	cs.End = time.Now()
+
+	return cs
+}
+
+// changeSetEvent creates the event written to the sinks for cs. The changes are nested in it.
+func (a *Agent) changeSetEvent(cs *changeSet) sink.Event {
+	e := sink.Event{
+		Kind:        sink.KindChangeSet,
+		IssuedAt:    time.Now().Unix(),
+		FsObject:    models.FsObject{Path: cs.Reason, Created: cs.Start.Unix(), Modified: cs.End.Unix()},
+		Maintenance: cs.Reason,
+	}
+
+	for _, c := range cs.list() {
+		verdict := a.verifyPackage(&c.New)
+
+		change := newEvent(c, nil, verdict)
+		change.Maintenance = cs.Reason
+		e.Changes = append(e.Changes, change)
+	}
+
+	return e
+}
+
+// reportChangeSet writes cs to the sinks as a single event and reports each of its changes to the server.
+func (a *Agent) reportChangeSet(ctx context.Context, cs *changeSet) error {
+	if cs == nil {
+		return nil
+	}
+
+	changes := cs.list()
+	log.Info().Str("maintenance", cs.Reason).Msgf("reporting change set with %d changes", len(changes))
+
+	e := a.changeSetEvent(cs)
+	if err := a.writeEvent(e); err != nil {
+		log.Warn().Err(err).Msg("failed to write change set to sinks")
+	}
+
+	for i, c := range changes {
+		err := a.sendEvent(ctx, e.Changes[i])
+		if err != nil {
+			return err
+		}
+
+		a.updateState(c)
+	}
+
+	return nil
+}
+
+// flushChangeSet reports the changes collected during maintenance which did not end yet, so they are not lost on shutdown.
+func (a *Agent) flushChangeSet() {
+	cs := a.takeChangeSet()
+	if cs == nil {
+		return
+	}
+
+	var err error
+	if a.conf.Offline.Enabled {
+		err = a.writeChangeSet(cs)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), stoppingTimeout)
+		defer cancel()
+
+		err = a.reportChangeSet(ctx, cs)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to flush change set")
+	}
+}
@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"github.com/Leantar/fimagent/modules/metrics"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"io"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+)
+
+var (
+	rpcDuration  = metrics.NewHistogramVec("fim_rpc_duration_seconds", "Duration of RPCs to the server. Streams are measured until the agent closed its side.", "method", metrics.DefBuckets)
+	rpcErrors    = metrics.NewCounterVec("fim_rpc_errors_total", "Failed RPCs to the server.", "method")
+	reconnects   = metrics.NewCounter("fim_reconnects_total", "Connections to the server which were established again after they were lost.")
+	scanDuration = metrics.NewGauge("fim_scan_duration_seconds", "Duration of the last full scan.")
+	scanObjects  = metrics.NewGauge("fim_scan_objects", "Objects found by the last full scan.")
+	scansTotal   = metrics.NewCounter("fim_scans_total", "Completed full scans.")
+)
+
+type MetricsConfig struct {
+	// Address is the address of the HTTP listener serving metrics at /metrics, e.g. 127.0.0.1:9469. Metrics are not served if empty
+	Address string `yaml:"address"`
+}
+
+// serveMetrics serves the Prometheus metrics until the listener fails.
+func (a *Agent) serveMetrics() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	log.Info().Msgf("serving metrics on %s", a.conf.Metrics.Address)
+
+	err := http.ListenAndServe(a.conf.Metrics.Address, mux)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to serve metrics")
+	}
+}
+
+// watchConnection counts how often the connection to the server was established again after it was lost.
+func (a *Agent) watchConnection() {
+	state := a.conn.GetState()
+	ready := state == connectivity.Ready
+
+	for a.conn.WaitForStateChange(context.Background(), state) {
+		state = a.conn.GetState()
+
+		switch state {
+		case connectivity.Ready:
+			if ready {
+				reconnects.Inc()
+				log.Info().Msg("reconnected to server")
+			}
+			ready = true
+		case connectivity.Shutdown:
+			return
+		}
+	}
+}
+
+func (a *Agent) unaryMetricsInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	a.observeRPC(method, start, err)
+
+	return err
+}
+
+func (a *Agent) streamMetricsInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	start := time.Now()
+	s, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		a.observeRPC(method, start, err)
+		return nil, err
+	}
+
+	return &measuredStream{ClientStream: s, agent: a, method: method, start: start, once: &sync.Once{}}, nil
+}
+
+// measuredStream observes the duration of a stream once it failed, was closed or received its response.
+type measuredStream struct {
+	grpc.ClientStream
+	agent  *Agent
+	method string
+	start  time.Time
+	once   *sync.Once
+}
+
+func (s *measuredStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err != nil {
+		s.finish(err)
+	}
+
+	return err
+}
+
+func (s *measuredStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if errors.Is(err, io.EOF) {
+		s.finish(nil)
+	} else {
+		// All streams of the API are client streams, which end with a single response
+		s.finish(err)
+	}
+
+	return err
+}
+
+func (s *measuredStream) CloseSend() error {
+	err := s.ClientStream.CloseSend()
+	s.finish(err)
+
+	return err
+}
+
+func (s *measuredStream) finish(err error) {
+	s.once.Do(func() {
+		s.agent.observeRPC(s.method, s.start, err)
+	})
+}
+
+// observeRPC records the duration and result of an RPC. Successful RPCs are remembered as last contact with the server.
+func (a *Agent) observeRPC(method string, start time.Time, err error) {
+	name := path.Base(method)
+
+	rpcDuration.With(name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		rpcErrors.With(name).Inc()
+		return
+	}
+
+	a.mu.Lock()
+	a.lastContact = time.Now()
+	a.mu.Unlock()
+}
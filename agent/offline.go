@@ -0,0 +1,237 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Leantar/fimagent/models"
+	"github.com/Leantar/fimagent/modules/analyzer"
+	"github.com/Leantar/fimagent/modules/baseline"
+	"github.com/Leantar/fimagent/modules/sink"
+	"github.com/Leantar/fimagent/modules/systemd"
+	"github.com/Leantar/fimagent/modules/watcher"
+	"github.com/rs/zerolog/log"
+	"os"
+	"time"
+)
+
+type OfflineConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	WatchedPaths []string `yaml:"watched_paths"`
+	BaselineFile string   `yaml:"baseline_file"`
+	KeyFile      string   `yaml:"key_file"`
+	// AlertFile receives all alerts as JSON lines in addition to the configured sinks
+	AlertFile string `yaml:"alert_file"`
+}
+
+// runOffline compares the file system against a locally stored baseline instead of reporting to a server.
+// If no baseline exists yet, it is created from the current state of the watched paths.
+func (a *Agent) runOffline() error {
+	conf := a.conf.Offline
+
+	if conf.AlertFile == "" && len(a.conf.Sinks) == 0 {
+		return errors.New("offline mode needs an alert file or a sink to write alerts to")
+	}
+
+	key, err := baseline.ReadKey(conf.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	objs, err := a.CollectFsObjects(conf.WatchedPaths)
+	if err != nil {
+		return err
+	}
+
+	b, err := baseline.Load(conf.BaselineFile, key)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Info().Msgf("creating local baseline %s", conf.BaselineFile)
+
+		b = baseline.New(conf.WatchedPaths, objs)
+		err = b.Save(conf.BaselineFile, key)
+	} else if err == nil {
+		for _, c := range b.Compare(objs) {
+			if c.Kind == watcher.KindDelete && a.inTruncatedScan(c.New.Path) {
+				// The object was not scanned, because a traversal limit was reached
+				continue
+			}
+
+			verdict := a.verifyPackage(&c.New)
+			if err := a.writeAlert(newEvent(c, nil, verdict)); err != nil {
+				return err
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	w, err := a.watch(conf.WatchedPaths)
+	if err != nil {
+		return err
+	}
+	notifyReady(conf.WatchedPaths)
+
+	ticker := time.NewTicker(changeSetInterval)
+	defer ticker.Stop()
+
+	ping, stopPing := watchdog()
+	defer stopPing()
+
+	for {
+		select {
+		case event := <-w.Events:
+			err := a.checkFsEvent(b, event)
+			if err != nil {
+				return err
+			}
+		case <-ticker.C:
+			err := a.writeChangeSet(a.completedChangeSet())
+			if err != nil {
+				return err
+			}
+		case <-ping:
+			notify(systemd.Watchdog)
+		}
+	}
+}
+
+// checkFsEvent compares the object of event against the baseline and writes an alert if it changed.
+func (a *Agent) checkFsEvent(b *baseline.Baseline, event watcher.Event) error {
+	a.eventReceived()
+
+	if e, ok := a.checkTamper(event); ok {
+		err := a.writeAlert(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	if a.onlyProtected(event.Path) {
+		return nil
+	}
+
+	var c baseline.Change
+	var changed bool
+	var findings []analyzer.Finding
+
+	if event.Kind() == watcher.KindDelete {
+		c, changed = b.CheckDeleted(event.Path)
+		a.analyzer.Forget(event.Path)
+	} else {
+		obj, err := models.NewFsObject(event.Path, a.objectOptions())
+		if errors.Is(err, models.ErrModifiedWhileHashing) {
+			// Files which are constantly written to would never be reported otherwise
+			log.Warn().Err(err).Msg("reporting file without hash")
+		} else if err != nil {
+			log.Warn().Caller().Err(err).Msg("failed to create new models")
+			return nil
+		}
+
+		c, changed = b.Check(obj)
+		findings = a.analyze(obj)
+	}
+
+	if !changed {
+		return nil
+	}
+
+	verdict := a.verifyPackage(&c.New)
+	reason, deferred := a.deferToChangeSet(c, event)
+	if deferred {
+		return nil
+	}
+
+	e := newEvent(c, findings, verdict)
+	e.Maintenance = reason
+	e.Pid = event.Pid
+	e.Processes = event.Processes
+
+	return a.writeAlert(e)
+}
+
+// writeChangeSet writes all changes made during maintenance as a single alert.
+func (a *Agent) writeChangeSet(cs *changeSet) error {
+	if cs == nil {
+		return nil
+	}
+
+	return a.writeAlert(a.changeSetEvent(cs))
+}
+
+// writeAlert writes e to all sinks. Only a failure to write the alert file is returned, because it is the record
+// of offline mode. Other sinks, e.g. an unreachable syslog server, must not stop the agent.
+func (a *Agent) writeAlert(e sink.Event) error {
+	a.mu.Lock()
+	sinks, alerts := a.sinks, a.alerts
+	a.mu.Unlock()
+
+	if err := sinks.Write(e); err != nil {
+		log.Warn().Err(err).Msg("failed to write alert to sinks")
+	}
+
+	if err := alerts.Write(e); err != nil {
+		return fmt.Errorf("failed to write alert: %w", err)
+	}
+
+	return nil
+}
+
+// ExportBaseline scans watchedPaths and writes a signed baseline to path.
+func (a *Agent) ExportBaseline(path string, watchedPaths []string) error {
+	key, err := baseline.ReadKey(a.conf.Offline.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	objs, err := a.CollectFsObjects(watchedPaths)
+	if err != nil {
+		return err
+	}
+
+	return baseline.New(watchedPaths, objs).Save(path, key)
+}
+
+// ImportBaseline verifies the baseline stored at path and installs it as the local baseline used in offline mode.
+func (a *Agent) ImportBaseline(path string) error {
+	key, err := baseline.ReadKey(a.conf.Offline.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	b, err := baseline.Load(path, key)
+	if err != nil {
+		return err
+	}
+
+	return b.Save(a.conf.Offline.BaselineFile, key)
+}
+
+// VerifyBaseline compares the current state of the file system against the baseline stored at path.
+func (a *Agent) VerifyBaseline(path string) ([]baseline.Change, error) {
+	key, err := baseline.ReadKey(a.conf.Offline.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := baseline.Load(path, key)
+	if err != nil {
+		return nil, err
+	}
+
+	objs, err := a.CollectFsObjects(b.WatchedPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []baseline.Change
+	for _, c := range b.Compare(objs) {
+		if c.Kind == watcher.KindDelete && a.inTruncatedScan(c.New.Path) {
+			continue
+		}
+
+		a.verifyPackage(&c.New)
+		changes = append(changes, c)
+	}
+
+	return changes, nil
+}
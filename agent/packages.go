@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"github.com/Leantar/fimagent/models"
+	"github.com/Leantar/fimagent/modules/pkgdb"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// packageReloadInterval is the minimum time between two loads of the package database. Package managers change
+// their databases many times during a single run, which would otherwise reload it on almost every event
+const packageReloadInterval = time.Minute
+
+// packageDB returns the database of files installed by package managers or nil if package verification is disabled.
+// It is reloaded if packages were installed, upgraded or removed since it was loaded, at most once per packageReloadInterval.
+func (a *Agent) packageDB() *pkgdb.DB {
+	if !a.conf.VerifyPackages {
+		return nil
+	}
+
+	a.mu.Lock()
+	db := a.packages
+	loadedAt := a.packagesLoaded
+	a.mu.Unlock()
+
+	if db != nil && (time.Since(loadedAt) < packageReloadInterval || !db.Stale()) {
+		return db
+	}
+
+	loaded, err := pkgdb.Load()
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to load package database")
+		return db
+	}
+	log.Info().Msgf("loaded %d paths owned by packages", loaded.Len())
+
+	a.mu.Lock()
+	a.packages = loaded
+	a.packagesLoaded = time.Now()
+	a.mu.Unlock()
+
+	return loaded
+}
+
+// tagPackages sets the owning package of all objs.
+func (a *Agent) tagPackages(objs []models.FsObject) {
+	db := a.packageDB()
+	if db == nil {
+		return
+	}
+
+	for i := range objs {
+		db.Tag(&objs[i])
+	}
+}
+
+// verifyPackage tags obj with its owning package and compares its content against the package manifest.
+func (a *Agent) verifyPackage(obj *models.FsObject) pkgdb.Verdict {
+	db := a.packageDB()
+	if db == nil {
+		return pkgdb.VerdictUnknown
+	}
+
+	db.Tag(obj)
+	if obj.Package == nil {
+		return pkgdb.VerdictUnknown
+	}
+
+	verdict, err := db.Verify(*obj, a.conf.VerifyPackagesRehash)
+	if err != nil {
+		log.Warn().Err(err).Msgf("failed to verify %s against its package", obj.Path)
+		return pkgdb.VerdictUnknown
+	}
+
+	switch verdict {
+	case pkgdb.VerdictUnexpectedModification:
+		log.Warn().Str("path", obj.Path).Str("package", obj.Package.Name).Msg("file differs from package manifest")
+	case pkgdb.VerdictMatchesPackage:
+		log.Info().Str("path", obj.Path).Str("package", obj.Package.Name).Msg("file matches package manifest")
+	}
+
+	return verdict
+}
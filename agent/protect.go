@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"errors"
+	"github.com/Leantar/fimagent/models"
+	"github.com/Leantar/fimagent/modules/sink"
+	"github.com/Leantar/fimagent/modules/walker"
+	"github.com/Leantar/fimagent/modules/watcher"
+	"github.com/rs/zerolog/log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stoppingTimeout limits how long reporting pending changes may delay stopping the agent
+const stoppingTimeout = 5 * time.Second
+
+// protectedFiles returns the executable, config and TLS files of the agent, which are always watched.
+func (a *Agent) protectedFiles() []string {
+	paths := []string{a.conf.ConfigFile, a.conf.CertFile, a.conf.CertKeyFile, a.conf.CaFile}
+
+	exe, err := os.Executable()
+	if err == nil {
+		paths = append(paths, exe)
+	} else {
+		log.Warn().Err(err).Msg("failed to find agent executable")
+	}
+
+	var files []string
+	seen := make(map[string]struct{})
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+
+		p, err := filepath.Abs(p)
+		if err != nil {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(p); err == nil {
+			p = resolved
+		}
+
+		if _, ok := seen[p]; !ok {
+			seen[p] = struct{}{}
+			files = append(files, p)
+		}
+	}
+
+	return files
+}
+
+// protectSelf hashes the protected files to detect later modifications.
+func (a *Agent) protectSelf() {
+	protected := make(map[string]models.FsObject)
+	for _, path := range a.protectedFiles() {
+		obj, err := models.NewFsObject(path, a.protectOptions())
+		if err != nil {
+			log.Warn().Err(err).Msgf("failed to hash protected file %s", path)
+			continue
+		}
+
+		protected[path] = obj
+	}
+
+	a.mu.Lock()
+	a.protected = protected
+	a.mu.Unlock()
+}
+
+// watchProtected adds watches for protected files, which are not already below a watched path.
+func (a *Agent) watchProtected(w *watcher.DebouncedWatcher, watchedPaths []string) {
+	a.mu.Lock()
+	var paths []string
+	for path := range a.protected {
+		paths = append(paths, path)
+	}
+	a.mu.Unlock()
+
+	for _, path := range paths {
+		if isBelowAny(path, watchedPaths) {
+			continue
+		}
+
+		err := w.AddRecursiveWatch(path)
+		if err != nil {
+			log.Warn().Err(err).Msgf("failed to watch protected file %s", path)
+		}
+	}
+}
+
+// checkTamper compares the state of a protected file after event with its state at startup.
+// It returns a tamper event if the file was changed. Protected files below a watched path are reported
+// to the server as ordinary change as well.
+func (a *Agent) checkTamper(event watcher.Event) (sink.Event, bool) {
+	a.mu.Lock()
+	old, ok := a.protected[event.Path]
+	a.mu.Unlock()
+	if !ok {
+		return sink.Event{}, false
+	}
+
+	obj, err := models.NewFsObject(event.Path, a.protectOptions())
+	if errors.Is(err, os.ErrNotExist) {
+		obj = models.FsObject{Path: event.Path}
+	} else if err != nil {
+		log.Warn().Err(err).Msgf("failed to hash protected file %s", event.Path)
+		obj = models.FsObject{Path: event.Path}
+	}
+
+	if obj.Equal(old) {
+		return sink.Event{}, false
+	}
+
+	// Remember the new state to only report every modification once
+	a.mu.Lock()
+	a.protected[event.Path] = obj
+	a.mu.Unlock()
+
+	log.Error().Str("path", event.Path).Msg("protected agent file was modified")
+
+	return sink.Event{
+		Kind:      sink.KindTamper,
+		IssuedAt:  time.Now().Unix(),
+		FsObject:  obj,
+		Baseline:  &old,
+		Pid:       event.Pid,
+		Processes: event.Processes,
+	}, true
+}
+
+// onlyProtected reports whether path is a protected file outside of the watched paths, which is only checked for tampering.
+func (a *Agent) onlyProtected(path string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	_, ok := a.protected[path]
+
+	return ok && !isBelowAny(path, a.watchedPaths)
+}
+
+// reportStopping tells the sinks that the agent is stopped on purpose.
+func (a *Agent) reportStopping() {
+	exe, _ := os.Executable()
+	e := sink.Event{
+		Kind:     sink.KindAgentStopping,
+		IssuedAt: time.Now().Unix(),
+		FsObject: models.FsObject{Path: exe},
+	}
+
+	err := a.writeEvent(e)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to write that the agent is stopping to sinks")
+	}
+}
+
+// protectOptions hashes protected files completely, regardless of the hash policies.
+func (a *Agent) protectOptions() models.Options {
+	opts := a.objectOptions()
+	opts.HashPolicies = nil
+
+	return opts
+}
+
+func isBelowAny(path string, paths []string) bool {
+	for _, p := range paths {
+		if walker.IsBelow(path, p) {
+			return true
+		}
+	}
+
+	return false
+}
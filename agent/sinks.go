@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"github.com/Leantar/fimagent/models"
+	"github.com/Leantar/fimagent/modules/analyzer"
+	"github.com/Leantar/fimagent/modules/baseline"
+	"github.com/Leantar/fimagent/modules/pkgdb"
+	"github.com/Leantar/fimagent/modules/sink"
+	"github.com/Leantar/fimproto/proto"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// openSinks creates all configured sinks. In offline mode the alert file is written by an additional file sink,
+// which is kept apart, because failing to write it stops the agent.
+func (a *Agent) openSinks() error {
+	sink.ProductVersion = Version
+
+	sinks, err := sink.Open(a.conf.Sinks)
+	if err != nil {
+		return err
+	}
+
+	var alerts sink.Multi
+	if a.conf.Offline.Enabled && a.conf.Offline.AlertFile != "" {
+		alerts, err = sink.Open([]sink.Config{{
+			Type: sink.TypeFile,
+			File: sink.FileConfig{Path: a.conf.Offline.AlertFile},
+		}})
+		if err != nil {
+			_ = sinks.Close()
+			return err
+		}
+	}
+
+	a.mu.Lock()
+	a.sinks = sinks
+	a.alerts = alerts
+	a.mu.Unlock()
+
+	return nil
+}
+
+func (a *Agent) closeSinks() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	err := errors.Join(a.sinks.Close(), a.alerts.Close())
+	a.sinks = nil
+	a.alerts = nil
+
+	return err
+}
+
+// writeEvent writes e to all sinks and the alert file.
+func (a *Agent) writeEvent(e sink.Event) error {
+	a.mu.Lock()
+	sinks, alerts := a.sinks, a.alerts
+	a.mu.Unlock()
+
+	return errors.Join(sinks.Write(e), alerts.Write(e))
+}
+
+// reportEvent writes e to all sinks and reports it to the server. Failing sinks do not stop the report.
+func (a *Agent) reportEvent(ctx context.Context, e sink.Event) error {
+	if err := a.writeEvent(e); err != nil {
+		log.Warn().Err(err).Msg("failed to write event to sinks")
+	}
+
+	return a.sendEvent(ctx, e)
+}
+
+// sendEvent only reports e to the server.
+func (a *Agent) sendEvent(ctx context.Context, e sink.Event) error {
+	evt := &proto.Event{
+		Kind:     e.Kind,
+		IssuedAt: e.IssuedAt,
+		FsObject: newProtoFsObject(e.FsObject),
+	}
+
+	_, err := a.client.ReportFsEvent(ctx, evt)
+	return err
+}
+
+// writeObject writes an object of a full scan to the sinks which include them.
+func (a *Agent) writeObject(obj models.FsObject) {
+	err := a.writeEvent(sink.Event{
+		Kind:     sink.KindObject,
+		IssuedAt: time.Now().Unix(),
+		FsObject: obj,
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to write object to sinks")
+	}
+}
+
+// newEvent creates the event for c. The last known state is only included if the change has one.
+func newEvent(c baseline.Change, findings []analyzer.Finding, verdict pkgdb.Verdict) sink.Event {
+	e := sink.Event{
+		Kind:           c.Kind,
+		IssuedAt:       time.Now().Unix(),
+		FsObject:       c.New,
+		Findings:       findings,
+		PackageVerdict: verdict,
+	}
+
+	if c.Old.Path != "" {
+		old := c.Old
+		e.Baseline = &old
+	}
+
+	return e
+}
@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Leantar/fimagent/models"
+	"github.com/Leantar/fimagent/modules/baseline"
+	"github.com/Leantar/fimagent/modules/merkle"
+	"github.com/Leantar/fimagent/modules/walker"
+	"github.com/Leantar/fimagent/modules/watcher"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// StatusConfig configures the status reported on startup.
+//
+// In incremental mode the changes are sent as ordinary events, so the server can not verify that its view matches
+// the local state. A full status is reported whenever the server requests a baseline, the state file is missing
+// or invalid, or it is older than MaxAge.
+type StatusConfig struct {
+	// Incremental enables sending only the changes since the last reported state instead of the full status
+	Incremental bool   `yaml:"incremental"`
+	StateFile   string `yaml:"state_file"`
+	KeyFile     string `yaml:"key_file"`
+	// MaxAge forces a full status once the last one is older. Zero disables it
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// loadState restores the state that was last reported to the server.
+func (a *Agent) loadState(watchedPaths []string) error {
+	key, err := baseline.ReadKey(a.conf.Status.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	state, err := baseline.Load(a.conf.Status.StateFile, key)
+	if err != nil {
+		return err
+	}
+
+	if !equalPaths(state.WatchedPaths, watchedPaths) {
+		return errors.New("watched paths have changed")
+	}
+
+	if maxAge := a.conf.Status.MaxAge; maxAge > 0 && time.Since(time.Unix(state.CreatedAt, 0)) > maxAge {
+		return fmt.Errorf("last full status is older than %s", maxAge)
+	}
+
+	a.mu.Lock()
+	a.state = state
+	a.stateKey = key
+	a.mu.Unlock()
+
+	return nil
+}
+
+// resetState replaces the local state after a full status was acknowledged by the server.
+func (a *Agent) resetState(watchedPaths []string, objs []models.FsObject) error {
+	if !a.conf.Status.Incremental {
+		return nil
+	}
+
+	key, err := baseline.ReadKey(a.conf.Status.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.state = baseline.New(watchedPaths, objs)
+	a.stateKey = key
+	a.mu.Unlock()
+
+	return a.saveState()
+}
+
+func (a *Agent) saveState() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.state == nil {
+		return nil
+	}
+
+	return a.state.Save(a.conf.Status.StateFile, a.stateKey)
+}
+
+// updateState applies a change which was acknowledged by the server.
+func (a *Agent) updateState(c baseline.Change) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.state != nil {
+		a.state.Apply(c)
+	}
+}
+
+// reportFsChanges sends only objects that were added, changed or removed since the last reported state.
+// Changes are determined by comparing the Merkle trees of every watched path, so unchanged trees are skipped entirely.
+func (a *Agent) reportFsChanges(watchedPaths []string, objs []models.FsObject) error {
+	a.mu.Lock()
+	previous := a.state.List()
+	a.mu.Unlock()
+
+	var changes []baseline.Change
+	for _, path := range walker.Roots(watchedPaths) {
+		if a.inTruncatedScan(path) {
+			// Objects which were not scanned would be reported as deleted
+			continue
+		}
+
+		old := merkle.Build(path, previous)
+		cur := merkle.Build(path, objs)
+
+		// The root is only used locally, the server can not compare it against its own view
+		log.Debug().Str("root", cur.Root()).Msgf("computed merkle root for %s", path)
+
+		if old.Root() == cur.Root() {
+			continue
+		}
+
+		for _, p := range merkle.Diff(old, cur) {
+			changes = append(changes, changeBetween(old.Find(p), cur.Find(p)))
+		}
+	}
+
+	ctx := context.Background()
+	for _, c := range changes {
+		verdict := a.verifyPackage(&c.New)
+
+		err := a.reportEvent(ctx, newEvent(c, nil, verdict))
+		if err != nil {
+			return err
+		}
+
+		a.updateState(c)
+	}
+
+	log.Info().Msgf("reported %d changes since last status", len(changes))
+
+	return a.saveState()
+}
+
+func changeBetween(old, cur *merkle.Node) baseline.Change {
+	var c baseline.Change
+	var hasOld, hasCur bool
+
+	if old != nil {
+		c.Old, hasOld = old.Object()
+	}
+	if cur != nil {
+		c.New, hasCur = cur.Object()
+	}
+
+	switch {
+	case !hasOld:
+		c.Kind = watcher.KindCreate
+	case !hasCur:
+		c.Kind = watcher.KindDelete
+		c.New = models.FsObject{Path: c.Old.Path}
+	default:
+		c.Kind = watcher.KindChange
+	}
+
+	return c
+}
+
+func equalPaths(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
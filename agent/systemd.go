@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"fmt"
+	"github.com/Leantar/fimagent/modules/systemd"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// notify passes states to systemd. Failures are only logged, because the agent works without a service manager.
+func notify(states ...string) {
+	err := systemd.Notify(states...)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to notify systemd")
+	}
+}
+
+// notifyReady tells systemd that all watches are in place and the initial state was reported.
+func notifyReady(watchedPaths []string) {
+	notify(systemd.Ready, systemd.Status(fmt.Sprintf("Watching %d paths", len(watchedPaths))))
+}
+
+// scanProgressInterval is how often the progress of a scan is reported. The timeout of systemd is extended by
+// twice the interval every time, so it does not stop the agent while the initial scan exceeds TimeoutStartSec
+const scanProgressInterval = 30 * time.Second
+
+// notifyScanProgress reports the elapsed time of a scan which started at start until done is closed.
+func notifyScanProgress(start time.Time, paths int, done <-chan struct{}) {
+	ticker := time.NewTicker(scanProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			elapsed := time.Since(start).Round(time.Second)
+			notify(systemd.Status(fmt.Sprintf("Scanning %d paths for %s", paths, elapsed)), systemd.ExtendTimeout(2*scanProgressInterval))
+		case <-done:
+			return
+		}
+	}
+}
+
+// watchdog returns a channel which ticks at half the watchdog timeout configured with WatchdogSec.
+// The main loops ping the watchdog on every tick, so systemd restarts the agent once a loop hangs.
+// The channel is nil if the watchdog is disabled, which blocks forever in a select.
+func watchdog() (<-chan time.Time, func()) {
+	interval := systemd.WatchdogInterval()
+	if interval == 0 {
+		return nil, func() {}
+	}
+
+	log.Info().Msgf("pinging systemd watchdog every %s", interval/2)
+
+	ticker := time.NewTicker(interval / 2)
+	return ticker.C, ticker.Stop
+}
@@ -0,0 +1,4 @@
+package agent
+
+// Version is overwritten at build time using -ldflags "-X github.com/Leantar/fimagent/agent.Version=<version>"
+var Version = "dev"
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Leantar/fimagent/agent"
+	"github.com/Leantar/fimagent/models"
+)
+
+func scan(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	// The agent skips watched paths which do not exist, but a path given on the command line is most likely a typo
+	for _, path := range args {
+		if _, err := os.Lstat(path); err != nil {
+			return err
+		}
+	}
+
+	objs, err := agent.New(agent.Config{}).CollectFsObjects(args)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, obj := range objs {
+		if err := enc.Encode(obj); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func hash(args []string) error {
+	fs := flag.NewFlagSet("hash", flag.ContinueOnError)
+	algorithms := fs.String("algorithms", string(models.Blake3), "Comma separated list of hash algorithms (blake3, sha256, sha1, md5)")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if fs.NArg() == 0 {
+		return errUsage
+	}
+
+	var opts models.Options
+	for _, alg := range strings.Split(*algorithms, ",") {
+		opts.HashAlgorithms = append(opts.HashAlgorithms, models.HashAlgorithm(strings.TrimSpace(alg)))
+	}
+
+	for _, path := range fs.Args() {
+		obj, err := models.NewFsObject(path, opts)
+		if err != nil {
+			return err
+		}
+
+		if obj.Hash == "" {
+			return fmt.Errorf("%s is not a regular file", path)
+		}
+
+		for _, alg := range opts.HashAlgorithms {
+			fmt.Printf("%s  %s  %s\n", alg, obj.Hashes[alg], path)
+		}
+	}
+
+	return nil
+}
+
+func manageBaseline(args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+
+	conf := loadConfig()
+	a := agent.New(conf)
+
+	switch args[0] {
+	case "export":
+		paths := args[2:]
+		if len(paths) == 0 {
+			paths = conf.Offline.WatchedPaths
+		}
+
+		return a.ExportBaseline(args[1], paths)
+	case "import":
+		if len(args) != 2 {
+			return errUsage
+		}
+
+		return a.ImportBaseline(args[1])
+	default:
+		return errUsage
+	}
+}
+
+func verify(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	changes, err := agent.New(loadConfig()).VerifyBaseline(args[0])
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, c := range changes {
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+	}
+
+	if len(changes) > 0 {
+		return fmt.Errorf("found %d changes", len(changes))
+	}
+
+	return nil
+}
+
+func status(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	s, err := agent.QueryStatus(loadConfig().Admin)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(s)
+}
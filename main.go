@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
@@ -12,31 +14,85 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+const usage = `Usage: fimagent [-config path] [command] [arguments]
+
+Commands:
+  run                                connect to the server (or run offline) and watch for changes (default)
+  scan <path>...                     print the state of every object below the given paths as JSON
+  hash [-algorithms list] <file>...  print the hashes of the given files
+  baseline export <file> [path...]   scan the given paths (default: offline watched paths) and write a signed baseline
+  baseline import <file>             verify a signed baseline and install it as the offline baseline
+  verify <baseline-file>             compare the file system against a signed baseline
+  status                             print the status of the running agent as JSON
+  version                            print the agent version
+
+Flags:
+`
+
 var (
 	configPath = flag.String("config", "config.yaml", "Specify a path to load the config from")
 )
 
+var errUsage = errors.New("invalid arguments")
+
 func main() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 
 	// Parse command line arguments
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	var conf agent.Config
-	err := config.FromYamlFile(*configPath, &conf)
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"run"}
+	}
+
+	var err error
+	switch args[0] {
+	case "run":
+		run()
+	case "scan":
+		err = scan(args[1:])
+	case "hash":
+		err = hash(args[1:])
+	case "baseline":
+		err = manageBaseline(args[1:])
+	case "verify":
+		err = verify(args[1:])
+	case "status":
+		err = status(args[1:])
+	case "version":
+		fmt.Println(agent.Version)
+	default:
+		err = errUsage
+	}
+
+	if errors.Is(err, errUsage) {
+		flag.Usage()
+		os.Exit(2)
+	}
 	if err != nil {
-		log.Fatal().Caller().Err(err).Msg("failed to read config")
+		log.Fatal().Err(err).Msgf("%s failed", args[0])
 	}
+}
+
+func run() {
+	conf := loadConfig()
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
 	a := agent.New(conf)
 
-	err = a.Connect()
-	if err != nil {
-		log.Fatal().Caller().Err(err).Msg("failed to connect to server ")
+	if !conf.Offline.Enabled {
+		err := a.Connect()
+		if err != nil {
+			log.Fatal().Caller().Err(err).Msg("failed to connect to server ")
+		}
 	}
 
 	go func() {
@@ -48,8 +104,19 @@ func main() {
 
 	<-quit
 
-	err = a.Stop()
+	err := a.Stop()
 	if err != nil {
 		log.Fatal().Caller().Err(err).Msg("failed to stop agent")
 	}
 }
+
+func loadConfig() agent.Config {
+	var conf agent.Config
+	err := config.FromYamlFile(*configPath, &conf)
+	if err != nil {
+		log.Fatal().Caller().Err(err).Msg("failed to read config")
+	}
+	conf.ConfigFile = *configPath
+
+	return conf
+}
@@ -1,34 +1,253 @@
 package models
 
 import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/Leantar/fimagent/modules/metrics"
 	"github.com/zeebo/blake3"
+	"hash"
 	"io"
 	"os"
 )
 
+// maxHashAttempts is the number of times hashing a file is retried if it is modified while being read
+const maxHashAttempts = 3
+
+// ErrModifiedWhileHashing is returned by NewFsObject together with the metadata of the file, if its content kept
+// changing while it was hashed. The returned object has no digest and HashUnavailable as mode.
+var ErrModifiedWhileHashing = errors.New("file was modified while hashing")
+
+var (
+	filesHashed = metrics.NewCounter("fim_files_hashed_total", "Regular files whose content was hashed.")
+	bytesHashed = metrics.NewCounter("fim_hashed_bytes_total", "Bytes of file content read for hashing.")
+)
+
+type HashAlgorithm string
+
+const (
+	Blake3 HashAlgorithm = "blake3"
+	SHA256 HashAlgorithm = "sha256"
+	SHA1   HashAlgorithm = "sha1"
+	MD5    HashAlgorithm = "md5"
+)
+
+// FsObject is the state of a file system object. Hash is the digest of the first configured hash algorithm,
+// which is the one reported to the server.
 type FsObject struct {
-	Path     string
-	Hash     string
-	Created  int64
-	Modified int64
-	Uid      uint32
-	Gid      uint32
-	Mode     uint32
+	Path     string                   `json:"path"`
+	Hash     string                   `json:"hash"`
+	Hashes   map[HashAlgorithm]string `json:"hashes,omitempty"`
+	HashMode HashMode                 `json:"hash_mode,omitempty"`
+	Size     int64                    `json:"size"`
+	Created  int64                    `json:"created"`
+	Modified int64                    `json:"modified"`
+	Times    Timestamps               `json:"times"`
+	Uid      uint32                   `json:"uid"`
+	Gid      uint32                   `json:"gid"`
+	Mode     uint32                   `json:"mode"`
+	// Package is set if the object was installed by a package manager. It is not part of the object state
+	Package *Package `json:"package,omitempty"`
+}
+
+// Package is the package that owns a path and the digest of the content it shipped.
+// Digest is empty for objects without content like directories.
+type Package struct {
+	Name      string        `json:"name"`
+	Algorithm HashAlgorithm `json:"algorithm"`
+	Digest    string        `json:"digest,omitempty"`
+}
+
+// Throttler limits the rate at which objects are created and files are read.
+type Throttler interface {
+	WaitFile()
+	Reader(r io.Reader) io.Reader
+}
+
+// ReadOptions controls how file content is read while hashing. The zero value does not update the access time
+// and does not give the kernel any advice on caching.
+type ReadOptions struct {
+	// UpdateAtime disables O_NOATIME, so reading a file updates its access time
+	UpdateAtime bool `yaml:"update_atime"`
+	// Sequential advises the kernel that files are read sequentially, which increases the read ahead
+	Sequential bool `yaml:"sequential"`
+	// DropCache advises the kernel to remove the file content from the page cache after it was read
+	DropCache bool `yaml:"drop_cache"`
+}
+
+// Options controls how objects are created. The zero value hashes files using BLAKE3 without throttling.
+type Options struct {
+	HashAlgorithms []HashAlgorithm
+	HashPolicies   []HashPolicy
+	Read           ReadOptions
+	Throttler      Throttler
+}
+
+// Equal reports whether both objects have the same attributes and digests. The package is ignored.
+func (o FsObject) Equal(other FsObject) bool {
+	if o.Path != other.Path ||
+		o.Hash != other.Hash ||
+		o.HashMode != other.HashMode ||
+		o.Size != other.Size ||
+		o.Created != other.Created ||
+		o.Modified != other.Modified ||
+		o.Uid != other.Uid ||
+		o.Gid != other.Gid ||
+		o.Mode != other.Mode ||
+		!o.Times.Equal(other.Times) ||
+		len(o.Hashes) != len(other.Hashes) {
+		return false
+	}
+
+	for alg, digest := range o.Hashes {
+		if other.Hashes[alg] != digest {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (o Options) hashAlgorithms() []HashAlgorithm {
+	if len(o.HashAlgorithms) == 0 {
+		return []HashAlgorithm{Blake3}
+	}
+
+	return o.HashAlgorithms
+}
+
+func (o Options) waitFile() {
+	if o.Throttler != nil {
+		o.Throttler.WaitFile()
+	}
 }
 
-func hashFile(path string) (string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return "", fmt.Errorf("failed to open file: %w", err)
+func (o Options) reader(r io.Reader) io.Reader {
+	if o.Throttler != nil {
+		return o.Throttler.Reader(r)
+	}
+
+	return r
+}
+
+func newHash(alg HashAlgorithm) (hash.Hash, error) {
+	switch alg {
+	case Blake3:
+		return blake3.New(), nil
+	case SHA256:
+		return sha256.New(), nil
+	case SHA1:
+		return sha1.New(), nil
+	case MD5:
+		return md5.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %q", alg)
+	}
+}
+
+// unhashed marks obj as having no digest, because its content changed while it was hashed.
+func unhashed(obj FsObject) FsObject {
+	obj.Hash = ""
+	obj.Hashes = nil
+	obj.HashMode = HashUnavailable
+
+	return obj
+}
+
+// retryHashing calls fn again if the file was modified while hashing, up to maxHashAttempts times.
+func retryHashing(fn func() (FsObject, error)) (obj FsObject, err error) {
+	for i := 0; i < maxHashAttempts; i++ {
+		obj, err = fn()
+		if !errors.Is(err, ErrModifiedWhileHashing) {
+			break
+		}
+	}
+
+	return
+}
+
+// hashFile computes the digests of all algorithms in a single pass over the content of file.
+// Depending on the hash policy for the path only parts of the file are hashed. This is recorded in obj.HashMode.
+// It also sets obj.Hash to the digest of the first algorithm.
+func hashFile(obj *FsObject, file *os.File, opts Options) error {
+	mode := HashFull
+	var segments []segment
+
+	policy := opts.hashPolicy(obj.Path)
+	if policy != nil && obj.Size > policy.MaxSize {
+		if policy.Mode == HashSkip {
+			obj.HashMode = HashSkip
+			return nil
+		}
+
+		segments = policy.segments(policy.Mode, obj.Size)
+		if segments != nil {
+			mode = policy.Mode
+		}
+	}
+
+	algs := opts.hashAlgorithms()
+	hashers := make(map[HashAlgorithm]hash.Hash, len(algs))
+	writers := make([]io.Writer, 0, len(algs))
+
+	for _, alg := range algs {
+		h, err := newHash(alg)
+		if err != nil {
+			return err
+		}
+
+		hashers[alg] = h
+		writers = append(writers, h)
+	}
+
+	adviseBeforeRead(file, opts.Read)
+	defer adviseAfterRead(file, opts.Read)
+
+	var err error
+	if mode == HashFull && policy != nil && policy.DetectSparse {
+		segments, err = dataSegments(file, obj.Size)
+		if err != nil {
+			return fmt.Errorf("failed to detect sparse file: %w", err)
+		}
+
+		if segments != nil {
+			mode = HashSparse
+		}
+	}
+
+	w := io.MultiWriter(writers...)
+	if segments == nil {
+		n, err := io.Copy(w, opts.reader(file))
+		bytesHashed.Add(uint64(n))
+		if err != nil {
+			return fmt.Errorf("failed to copy file content: %w", err)
+		}
+	} else {
+		// The size and offsets are part of the hash, so moving data around inside the file changes it
+		_ = binary.Write(w, binary.LittleEndian, obj.Size)
+
+		for _, s := range segments {
+			_ = binary.Write(w, binary.LittleEndian, s.offset)
+
+			n, err := io.Copy(w, opts.reader(io.NewSectionReader(file, s.offset, s.length)))
+			bytesHashed.Add(uint64(n))
+			if err != nil {
+				return fmt.Errorf("failed to copy file content: %w", err)
+			}
+		}
 	}
-	defer file.Close()
+	filesHashed.Inc()
 
-	hasher := blake3.New()
-	if _, err := io.Copy(hasher, file); err != nil {
-		return "", fmt.Errorf("failed to copy file content: %w", err)
+	obj.HashMode = mode
+	obj.Hashes = make(map[HashAlgorithm]string, len(hashers))
+	for alg, h := range hashers {
+		obj.Hashes[alg] = hex.EncodeToString(h.Sum(nil))
 	}
+	obj.Hash = obj.Hashes[algs[0]]
 
-	return hex.EncodeToString(hasher.Sum(nil)), nil
+	return nil
 }
@@ -0,0 +1,66 @@
+//go:build darwin
+
+package models
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// Darwin does not support opening files without updating the access time
+const noAtimeFlag = 0
+
+func lstat(path string) (fileStat, error) {
+	var stat unix.Stat_t
+
+	err := unix.Lstat(path, &stat)
+	if err != nil {
+		return fileStat{}, err
+	}
+
+	return newFileStat(stat), nil
+}
+
+func fstat(f *os.File) (fileStat, error) {
+	var stat unix.Stat_t
+
+	err := unix.Fstat(int(f.Fd()), &stat)
+	if err != nil {
+		return fileStat{}, err
+	}
+
+	return newFileStat(stat), nil
+}
+
+func newFileStat(stat unix.Stat_t) fileStat {
+	return fileStat{
+		dev:  uint64(stat.Dev),
+		ino:  stat.Ino,
+		mode: uint32(stat.Mode),
+		uid:  stat.Uid,
+		gid:  stat.Gid,
+		size: stat.Size,
+		times: Timestamps{
+			Access:    stat.Atim.Nano(),
+			Modify:    stat.Mtim.Nano(),
+			Change:    stat.Ctim.Nano(),
+			Birth:     stat.Btim.Nano(),
+			Available: TimeAccess | TimeModify | TimeChange | TimeBirth,
+		},
+	}
+}
+
+// adviseBeforeRead uses fcntl, as Darwin does not support posix_fadvise.
+// F_NOCACHE has to be set before reading, because there is no way to drop the cache afterwards.
+func adviseBeforeRead(f *os.File, opts ReadOptions) {
+	if opts.Sequential {
+		// Advice is only a hint. Errors are not relevant
+		_, _ = unix.FcntlInt(f.Fd(), unix.F_RDAHEAD, 1)
+	}
+
+	if opts.DropCache {
+		_, _ = unix.FcntlInt(f.Fd(), unix.F_NOCACHE, 1)
+	}
+}
+
+func adviseAfterRead(_ *os.File, _ ReadOptions) {}
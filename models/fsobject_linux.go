@@ -0,0 +1,114 @@
+//go:build linux
+
+package models
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+const (
+	statxMask   = unix.STATX_BASIC_STATS | unix.STATX_BTIME
+	noAtimeFlag = unix.O_NOATIME
+)
+
+func lstat(path string) (fileStat, error) {
+	return statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+func fstat(f *os.File) (fileStat, error) {
+	return statx(int(f.Fd()), "", unix.AT_EMPTY_PATH)
+}
+
+func statx(dirfd int, path string, flags int) (fileStat, error) {
+	var stat unix.Statx_t
+
+	err := unix.Statx(dirfd, path, flags, statxMask, &stat)
+	if errors.Is(err, unix.ENOSYS) {
+		// statx is only available since Linux 4.11
+		return statFallback(dirfd, path)
+	}
+	if err != nil {
+		return fileStat{}, err
+	}
+
+	var times Timestamps
+	fields := []struct {
+		mask  uint32
+		field TimeFields
+		ts    unix.StatxTimestamp
+		dest  *int64
+	}{
+		{unix.STATX_ATIME, TimeAccess, stat.Atime, &times.Access},
+		{unix.STATX_MTIME, TimeModify, stat.Mtime, &times.Modify},
+		{unix.STATX_CTIME, TimeChange, stat.Ctime, &times.Change},
+		{unix.STATX_BTIME, TimeBirth, stat.Btime, &times.Birth},
+	}
+
+	// The kernel only sets the bits of fields which are supported by the file system
+	for _, f := range fields {
+		if stat.Mask&f.mask != 0 {
+			*f.dest = f.ts.Sec*1e9 + int64(f.ts.Nsec)
+			times.Available |= f.field
+		}
+	}
+
+	// Some file systems set the mask bit but report zero if they do not store the birth time
+	if times.Birth == 0 {
+		times.Available &^= TimeBirth
+	}
+
+	return fileStat{
+		dev:   unix.Mkdev(stat.Dev_major, stat.Dev_minor),
+		ino:   stat.Ino,
+		mode:  uint32(stat.Mode),
+		uid:   stat.Uid,
+		gid:   stat.Gid,
+		size:  int64(stat.Size),
+		times: times,
+	}, nil
+}
+
+// statFallback uses fstat if path is empty and lstat otherwise.
+func statFallback(fd int, path string) (fileStat, error) {
+	var stat unix.Stat_t
+	var err error
+
+	if path == "" {
+		err = unix.Fstat(fd, &stat)
+	} else {
+		err = unix.Lstat(path, &stat)
+	}
+	if err != nil {
+		return fileStat{}, err
+	}
+
+	return fileStat{
+		dev:  stat.Dev,
+		ino:  stat.Ino,
+		mode: stat.Mode,
+		uid:  stat.Uid,
+		gid:  stat.Gid,
+		size: stat.Size,
+		times: Timestamps{
+			Access:    stat.Atim.Nano(),
+			Modify:    stat.Mtim.Nano(),
+			Change:    stat.Ctim.Nano(),
+			Available: TimeAccess | TimeModify | TimeChange,
+		},
+	}, nil
+}
+
+func adviseBeforeRead(f *os.File, opts ReadOptions) {
+	if opts.Sequential {
+		// Advice is only a hint. Errors are not relevant
+		_ = unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_SEQUENTIAL)
+	}
+}
+
+func adviseAfterRead(f *os.File, opts ReadOptions) {
+	if opts.DropCache {
+		_ = unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_DONTNEED)
+	}
+}
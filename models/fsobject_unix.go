@@ -3,8 +3,12 @@
 package models
 
 import (
+	"errors"
 	"fmt"
 	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"time"
 )
 
 const (
@@ -12,33 +16,165 @@ const (
 	S_IFREG = 0o0100000
 )
 
-func NewFsObject(path string) (FsObject, error) {
-	var stat unix.Stat_t
+const openFlags = unix.O_RDONLY |
+	unix.O_NOFOLLOW |
+	// Prevents blocking if the path was replaced by a FIFO
+	unix.O_NONBLOCK |
+	unix.O_CLOEXEC
 
-	err := unix.Lstat(path, &stat)
+// fileStat contains the attributes of a file returned by lstat or fstat.
+type fileStat struct {
+	dev   uint64
+	ino   uint64
+	mode  uint32
+	uid   uint32
+	gid   uint32
+	size  int64
+	times Timestamps
+}
+
+func NewFsObject(path string, opts Options) (FsObject, error) {
+	opts.waitFile()
+
+	stat, err := lstat(path)
 	if err != nil {
 		return FsObject{}, fmt.Errorf("failed to stat path: %w", err)
 	}
 
-	created, _ := stat.Ctim.Unix()
-	modified, _ := stat.Mtim.Unix()
+	// Check if file is regular
+	if stat.mode&S_IFMT != S_IFREG {
+		return stat.fsObject(path), nil
+	}
+
+	return retryHashing(func() (FsObject, error) {
+		return newRegularFsObject(path, opts)
+	})
+}
+
+// newRegularFsObject reads the metadata and content of a regular file through the same file descriptor.
+// This makes sure that both belong to the same inode, even if the path is replaced concurrently.
+func newRegularFsObject(path string, opts Options) (FsObject, error) {
+	f, err := openFile(path, !opts.Read.UpdateAtime)
+	if err != nil {
+		return FsObject{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	before, err := fstat(f)
+	if err != nil {
+		return FsObject{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	obj := before.fsObject(path)
+
+	if before.mode&S_IFMT != S_IFREG {
+		// The path was replaced by a non regular file after lstat
+		return obj, nil
+	}
+
+	err = hashFile(&obj, f, opts)
+	if err != nil {
+		return FsObject{}, err
+	}
+
+	after, err := fstat(f)
+	if err != nil {
+		return FsObject{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	if !before.sameContent(after) {
+		return unhashed(after.fsObject(path)), fmt.Errorf("%s: %w", path, ErrModifiedWhileHashing)
+	}
+
+	return obj, nil
+}
+
+// openFile opens path for reading without following symlinks. If noAtime is set, reading the file does not update
+// its access time where permitted.
+func openFile(path string, noAtime bool) (*os.File, error) {
+	if !noAtime || noAtimeFlag == 0 {
+		fd, err := unix.Open(path, openFlags, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		return os.NewFile(uintptr(fd), path), nil
+	}
+
+	fd, err := unix.Open(path, openFlags|noAtimeFlag, 0)
+	if errors.Is(err, unix.EPERM) {
+		// O_NOATIME is only permitted for the owner of the file or with CAP_FOWNER
+		fd, err = unix.Open(path, openFlags, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(fd), path), nil
+}
 
-	obj := FsObject{
+func (s fileStat) fsObject(path string) FsObject {
+	return FsObject{
 		Path:     path,
-		Created:  created,
-		Modified: modified,
-		Uid:      stat.Uid,
-		Gid:      stat.Gid,
-		Mode:     uint32(stat.Mode),
+		Size:     s.size,
+		Created:  s.times.created(),
+		Modified: s.times.Modify / int64(time.Second),
+		Times:    s.times,
+		Uid:      s.uid,
+		Gid:      s.gid,
+		Mode:     s.mode,
 	}
+}
 
-	// Check if file is regular
-	if stat.Mode&S_IFMT == S_IFREG {
-		obj.Hash, err = hashFile(path)
+// sameContent reports whether s and other describe the same inode without any modification in between.
+func (s fileStat) sameContent(other fileStat) bool {
+	return s.dev == other.dev &&
+		s.ino == other.ino &&
+		s.size == other.size &&
+		s.times.Modify == other.times.Modify &&
+		s.times.Change == other.times.Change
+}
+
+// dataSegments returns the data segments of f if it is sparse and nil otherwise.
+func dataSegments(f *os.File, size int64) ([]segment, error) {
+	fd := int(f.Fd())
+	// Seeking for holes moves the file offset. It has to be restored for sequential reads
+	defer unix.Seek(fd, 0, io.SeekStart)
+
+	hole, err := unix.Seek(fd, 0, unix.SEEK_HOLE)
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EOPNOTSUPP) {
+		// The file system does not support SEEK_HOLE
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if hole >= size {
+		return nil, nil
+	}
+
+	segments := []segment{}
+	var offset int64
+
+	for offset < size {
+		data, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// No data after offset
+			break
+		}
 		if err != nil {
-			return FsObject{}, err
+			return nil, err
 		}
+
+		hole, err := unix.Seek(fd, data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+
+		segments = append(segments, segment{data, hole - data})
+		offset = hole
 	}
 
-	return obj, nil
+	return segments, nil
 }
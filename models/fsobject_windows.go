@@ -9,32 +9,90 @@ import (
 	"time"
 )
 
-func NewFsObject(path string) (FsObject, error) {
+func NewFsObject(path string, opts Options) (FsObject, error) {
+	opts.waitFile()
+
 	info, err := os.Stat(path)
 	if err != nil {
 		return FsObject{}, fmt.Errorf("failed to stat path: %w", err)
 	}
 
+	// Check if file is regular
+	if !info.Mode().IsRegular() {
+		return newFsObject(path, info), nil
+	}
+
+	return retryHashing(func() (FsObject, error) {
+		return newRegularFsObject(path, opts)
+	})
+}
+
+// newRegularFsObject reads the metadata and content of a regular file through the same handle.
+func newRegularFsObject(path string, opts Options) (FsObject, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FsObject{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	before, err := f.Stat()
+	if err != nil {
+		return FsObject{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	obj := newFsObject(path, before)
+
+	if !before.Mode().IsRegular() {
+		return obj, nil
+	}
+
+	err = hashFile(&obj, f, opts)
+	if err != nil {
+		return FsObject{}, err
+	}
+
+	after, err := f.Stat()
+	if err != nil {
+		return FsObject{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	if !os.SameFile(before, after) || before.Size() != after.Size() || !before.ModTime().Equal(after.ModTime()) {
+		return unhashed(newFsObject(path, after)), fmt.Errorf("%s: %w", path, ErrModifiedWhileHashing)
+	}
+
+	return obj, nil
+}
+
+func newFsObject(path string, info os.FileInfo) FsObject {
 	stat := info.Sys().(*windows.Win32FileAttributeData)
-	created := time.Unix(0, stat.CreationTime.Nanoseconds()).Unix()
-	modified := time.Unix(0, stat.LastWriteTime.Nanoseconds()).Unix()
 
-	obj := FsObject{
+	// Windows does not track the time of metadata changes
+	times := Timestamps{
+		Access:    stat.LastAccessTime.Nanoseconds(),
+		Modify:    stat.LastWriteTime.Nanoseconds(),
+		Birth:     stat.CreationTime.Nanoseconds(),
+		Available: TimeAccess | TimeModify | TimeBirth,
+	}
+
+	return FsObject{
 		Path:     path,
-		Created:  created,
-		Modified: modified,
+		Size:     info.Size(),
+		Created:  times.created(),
+		Modified: times.Modify / int64(time.Second),
+		Times:    times,
 		Uid:      0,
 		Gid:      0,
 		Mode:     uint32(info.Mode()),
 	}
+}
 
-	// Check if file is regular
-	if info.Mode().IsRegular() {
-		obj.Hash, err = hashFile(path)
-		if err != nil {
-			return FsObject{}, err
-		}
-	}
+// adviseBeforeRead is not supported on Windows.
+func adviseBeforeRead(_ *os.File, _ ReadOptions) {}
 
-	return obj, nil
+// adviseAfterRead is not supported on Windows.
+func adviseAfterRead(_ *os.File, _ ReadOptions) {}
+
+// dataSegments is not supported on Windows. All files are treated as not sparse.
+func dataSegments(_ *os.File, _ int64) ([]segment, error) {
+	return nil, nil
 }
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+type HashMode string
+
+const (
+	// HashFull hashes the complete file content
+	HashFull HashMode = ""
+	// HashSkip does not hash the file at all
+	HashSkip HashMode = "skip"
+	// HashHeadTail only hashes the first and last chunk of the file
+	HashHeadTail HashMode = "head_tail"
+	// HashSampled hashes chunks spread evenly across the file
+	HashSampled HashMode = "sampled"
+	// HashSparse hashes only the data segments of a sparse file together with their offsets
+	HashSparse HashMode = "sparse"
+	// HashUnavailable marks files which kept changing while they were hashed. They have no digest
+	HashUnavailable HashMode = "unavailable"
+)
+
+const (
+	defaultChunkSize = 1 << 20
+	defaultSamples   = 16
+)
+
+// HashPolicy controls how large files below Path are hashed.
+type HashPolicy struct {
+	Path string `yaml:"path"`
+	// Files larger than MaxSize bytes are hashed using Mode
+	MaxSize int64    `yaml:"max_size"`
+	Mode    HashMode `yaml:"mode"`
+	// ChunkSize is the number of bytes read per chunk in the head_tail and sampled modes
+	ChunkSize int64 `yaml:"chunk_size"`
+	// Samples is the number of chunks read in the sampled mode
+	Samples int `yaml:"samples"`
+	// DetectSparse skips holes of sparse files, which would otherwise be read as zeros
+	DetectSparse bool `yaml:"detect_sparse"`
+}
+
+type segment struct {
+	offset int64
+	length int64
+}
+
+// ReportedHash returns the hash as it is reported to the server.
+// Hashes which were not computed over the plain file content are prefixed with their mode, so they are not mistaken for full hashes.
+func (o FsObject) ReportedHash() string {
+	if o.HashMode == HashFull {
+		return o.Hash
+	}
+
+	return string(o.HashMode) + ":" + o.Hash
+}
+
+// hashPolicy returns the most specific policy for path or nil if there is none.
+func (o Options) hashPolicy(path string) *HashPolicy {
+	var policy *HashPolicy
+
+	for i := range o.HashPolicies {
+		p := &o.HashPolicies[i]
+		if !isBelow(path, p.Path) {
+			continue
+		}
+
+		if policy == nil || len(p.Path) > len(policy.Path) {
+			policy = p
+		}
+	}
+
+	return policy
+}
+
+func (p *HashPolicy) chunkSize() int64 {
+	if p.ChunkSize <= 0 {
+		return defaultChunkSize
+	}
+
+	return p.ChunkSize
+}
+
+func (p *HashPolicy) samples() int {
+	if p.Samples <= 0 {
+		return defaultSamples
+	}
+
+	return p.Samples
+}
+
+// segments returns the parts of a file of the given size which are hashed in mode.
+// It returns nil if the whole file has to be hashed.
+func (p *HashPolicy) segments(mode HashMode, size int64) []segment {
+	chunk := p.chunkSize()
+
+	switch mode {
+	case HashHeadTail:
+		if size <= 2*chunk {
+			return nil
+		}
+
+		return []segment{{0, chunk}, {size - chunk, chunk}}
+	case HashSampled:
+		n := int64(p.samples())
+		if size <= n*chunk {
+			return nil
+		}
+
+		if n == 1 {
+			return []segment{{0, chunk}}
+		}
+
+		// Spread the chunks evenly, so the first one starts at the beginning and the last one ends at the end of the file
+		step := (size - chunk) / (n - 1)
+		segments := make([]segment, 0, n)
+		for i := int64(0); i < n; i++ {
+			segments = append(segments, segment{i * step, chunk})
+		}
+
+		return segments
+	default:
+		return nil
+	}
+}
+
+func isBelow(path, dir string) bool {
+	dir = filepath.Clean(dir)
+	if path == dir {
+		return true
+	}
+
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
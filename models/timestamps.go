@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+)
+
+// TimeFields is a bitmask of timestamps
+type TimeFields uint8
+
+const (
+	TimeAccess TimeFields = 1 << iota
+	TimeModify
+	TimeChange
+	TimeBirth
+)
+
+// Timestamps holds the timestamps of an object in nanoseconds since the epoch.
+// Only the fields in Available are supported by the platform and file system. All others are zero.
+type Timestamps struct {
+	Access    int64      `json:"access"`
+	Modify    int64      `json:"modify"`
+	Change    int64      `json:"change"`
+	Birth     int64      `json:"birth"`
+	Available TimeFields `json:"available"`
+}
+
+func (f TimeFields) Has(field TimeFields) bool {
+	return f&field == field
+}
+
+// Equal compares all timestamps except the access time, which changes on every read.
+func (t Timestamps) Equal(other Timestamps) bool {
+	return t.Modify == other.Modify &&
+		t.Change == other.Change &&
+		t.Birth == other.Birth &&
+		t.Available == other.Available
+}
+
+// created returns the value of FsObject.Created in seconds. It stays the change time on platforms which track it,
+// so existing baselines do not change. The birth time is only used on Windows, which has no change time.
+func (t Timestamps) created() int64 {
+	if !t.Available.Has(TimeChange) && t.Available.Has(TimeBirth) {
+		return t.Birth / int64(time.Second)
+	}
+
+	return t.Change / int64(time.Second)
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCreated(t *testing.T) {
+	tests := []struct {
+		name  string
+		times Timestamps
+		want  int64
+	}{
+		{"change time", Timestamps{Change: 2 * int64(time.Second), Birth: int64(time.Second), Available: TimeChange | TimeBirth}, 2},
+		{"birth time without change time", Timestamps{Birth: int64(time.Second), Available: TimeBirth}, 1},
+		{"nothing available", Timestamps{}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := tt.times.created(); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
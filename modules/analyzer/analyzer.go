@@ -0,0 +1,97 @@
+package analyzer
+
+import (
+	"github.com/Leantar/fimagent/models"
+	"sync"
+)
+
+type Finding string
+
+const (
+	// FindingMtimeBackwards is reported if the content changed, but the modification time moved backwards
+	FindingMtimeBackwards Finding = "MTIME_BACKWARDS"
+	// FindingMtimeBeforeBirth is reported if the modification time is older than the birth time
+	FindingMtimeBeforeBirth Finding = "MTIME_BEFORE_BIRTH"
+	// FindingBinaryMetadataChange is reported if the change time of an executable changed without a change of its modification time
+	FindingBinaryMetadataChange Finding = "BINARY_METADATA_CHANGE"
+)
+
+const execBits = 0o111
+
+// Analyzer detects timestomping and suspicious metadata changes by comparing consecutive states of objects.
+// A nil Analyzer never reports findings.
+type Analyzer struct {
+	states map[string]models.FsObject
+	mu     *sync.Mutex
+}
+
+func New() *Analyzer {
+	return &Analyzer{
+		states: make(map[string]models.FsObject),
+		mu:     &sync.Mutex{},
+	}
+}
+
+// Seed sets the known states of objs without analyzing them.
+func (a *Analyzer) Seed(objs []models.FsObject) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, obj := range objs {
+		a.states[obj.Path] = obj
+	}
+}
+
+// Analyze compares obj against its last known state and remembers it as the new state.
+func (a *Analyzer) Analyze(obj models.FsObject) []Finding {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	old, ok := a.states[obj.Path]
+	a.states[obj.Path] = obj
+	a.mu.Unlock()
+
+	var findings []Finding
+
+	t := obj.Times
+	if t.Available.Has(models.TimeBirth|models.TimeModify) && t.Modify < t.Birth {
+		findings = append(findings, FindingMtimeBeforeBirth)
+	}
+
+	if !ok {
+		return findings
+	}
+
+	if obj.Hash != old.Hash && t.Modify < old.Times.Modify {
+		findings = append(findings, FindingMtimeBackwards)
+	}
+
+	if isExecutable(obj) && t.Available.Has(models.TimeChange) &&
+		t.Change != old.Times.Change && t.Modify == old.Times.Modify {
+		findings = append(findings, FindingBinaryMetadataChange)
+	}
+
+	return findings
+}
+
+// Forget removes the state of a deleted object.
+func (a *Analyzer) Forget(path string) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	delete(a.states, path)
+	a.mu.Unlock()
+}
+
+func isExecutable(obj models.FsObject) bool {
+	// Only regular files have a hash
+	return obj.Hash != "" && obj.Mode&execBits != 0
+}
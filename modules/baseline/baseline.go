@@ -0,0 +1,228 @@
+package baseline
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Leantar/fimagent/models"
+	"github.com/Leantar/fimagent/modules/watcher"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+var ErrInvalidSignature = errors.New("baseline: signature mismatch")
+
+type Baseline struct {
+	CreatedAt    int64                      `json:"created_at"`
+	WatchedPaths []string                   `json:"watched_paths"`
+	Objects      map[string]models.FsObject `json:"objects"`
+}
+
+// Change describes a difference between the baseline and the current state of a path.
+// Old is empty for created objects and New only carries the path for deleted objects.
+type Change struct {
+	Kind string          `json:"kind"`
+	Old  models.FsObject `json:"old"`
+	New  models.FsObject `json:"new"`
+}
+
+type signedFile struct {
+	Mac      string          `json:"mac"`
+	Baseline json.RawMessage `json:"baseline"`
+}
+
+func New(watchedPaths []string, objs []models.FsObject) *Baseline {
+	b := Baseline{
+		CreatedAt:    time.Now().Unix(),
+		WatchedPaths: watchedPaths,
+		Objects:      make(map[string]models.FsObject, len(objs)),
+	}
+
+	for _, obj := range objs {
+		b.Objects[obj.Path] = obj
+	}
+
+	return &b
+}
+
+// Load reads a baseline from path and verifies its HMAC-SHA256 signature using key.
+func Load(path string, key []byte) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("baseline: %w", err)
+	}
+
+	var f signedFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("baseline: %w", err)
+	}
+
+	mac, err := hex.DecodeString(f.Mac)
+	if err != nil {
+		return nil, fmt.Errorf("baseline: %w", err)
+	}
+
+	if !hmac.Equal(mac, sign(f.Baseline, key)) {
+		return nil, ErrInvalidSignature
+	}
+
+	var b Baseline
+	if err := json.Unmarshal(f.Baseline, &b); err != nil {
+		return nil, fmt.Errorf("baseline: %w", err)
+	}
+
+	if b.Objects == nil {
+		b.Objects = make(map[string]models.FsObject)
+	}
+
+	return &b, nil
+}
+
+// Save signs the baseline using key and atomically writes it to path.
+func (b *Baseline) Save(path string, key []byte) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return fmt.Errorf("baseline: %w", err)
+	}
+
+	out, err := json.Marshal(signedFile{
+		Mac:      hex.EncodeToString(sign(data, key)),
+		Baseline: data,
+	})
+	if err != nil {
+		return fmt.Errorf("baseline: %w", err)
+	}
+
+	// Write to a temporary file first, so a crash does not leave a truncated baseline behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("baseline: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(out); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("baseline: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("baseline: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("baseline: %w", err)
+	}
+
+	return nil
+}
+
+func (b *Baseline) Lookup(path string) (models.FsObject, bool) {
+	obj, ok := b.Objects[path]
+	return obj, ok
+}
+
+// List returns all objects of the baseline in no particular order.
+func (b *Baseline) List() []models.FsObject {
+	objs := make([]models.FsObject, 0, len(b.Objects))
+	for _, obj := range b.Objects {
+		objs = append(objs, obj)
+	}
+
+	return objs
+}
+
+// Set adds obj to the baseline or replaces the existing entry for its path.
+func (b *Baseline) Set(obj models.FsObject) {
+	b.Objects[obj.Path] = obj
+}
+
+func (b *Baseline) Delete(path string) {
+	delete(b.Objects, path)
+}
+
+// Apply updates the baseline so that it reflects the state after c.
+func (b *Baseline) Apply(c Change) {
+	if c.Kind == watcher.KindDelete {
+		b.Delete(c.New.Path)
+	} else {
+		b.Set(c.New)
+	}
+}
+
+// Check compares a single object against its baseline entry. It returns false if nothing changed.
+func (b *Baseline) Check(obj models.FsObject) (Change, bool) {
+	old, ok := b.Objects[obj.Path]
+	if !ok {
+		return Change{Kind: watcher.KindCreate, New: obj}, true
+	}
+
+	if !old.Equal(obj) {
+		return Change{Kind: watcher.KindChange, Old: old, New: obj}, true
+	}
+
+	return Change{}, false
+}
+
+// CheckDeleted reports a deletion if path is part of the baseline.
+func (b *Baseline) CheckDeleted(path string) (Change, bool) {
+	old, ok := b.Objects[path]
+	if !ok {
+		return Change{}, false
+	}
+
+	return Change{Kind: watcher.KindDelete, Old: old, New: models.FsObject{Path: path}}, true
+}
+
+// Compare returns all differences between the baseline and objs, sorted by path.
+func (b *Baseline) Compare(objs []models.FsObject) []Change {
+	var changes []Change
+	seen := make(map[string]struct{}, len(objs))
+
+	for _, obj := range objs {
+		seen[obj.Path] = struct{}{}
+
+		if c, ok := b.Check(obj); ok {
+			changes = append(changes, c)
+		}
+	}
+
+	for path := range b.Objects {
+		if _, ok := seen[path]; !ok {
+			c, _ := b.CheckDeleted(path)
+			changes = append(changes, c)
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].New.Path < changes[j].New.Path
+	})
+
+	return changes
+}
+
+// ReadKey reads the HMAC key from path. Leading and trailing whitespace is ignored.
+func ReadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("baseline: %w", err)
+	}
+
+	key := bytes.TrimSpace(data)
+	if len(key) == 0 {
+		return nil, errors.New("baseline: key file is empty")
+	}
+
+	return key, nil
+}
+
+func sign(data, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
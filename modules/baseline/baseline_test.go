@@ -0,0 +1,112 @@
+package baseline
+
+import (
+	"errors"
+	"github.com/Leantar/fimagent/models"
+	"github.com/Leantar/fimagent/modules/watcher"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func testBaseline() *Baseline {
+	return New([]string{"/etc"}, []models.FsObject{
+		{Path: "/etc/hosts", Hash: "a", Modified: 1},
+		{Path: "/etc/passwd", Hash: "b", Modified: 2},
+	})
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.json")
+
+	err := testBaseline().Save(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Load(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b.Objects) != 2 {
+		t.Fatalf("got %d objects, want 2", len(b.Objects))
+	}
+	if obj, ok := b.Lookup("/etc/hosts"); !ok || obj.Hash != "a" {
+		t.Errorf("got %+v, want hash a", obj)
+	}
+}
+
+func TestLoadTampered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.json")
+
+	err := testBaseline().Save(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := strings.Replace(string(data), `"hash":"a"`, `"hash":"c"`, 1)
+	if tampered == string(data) {
+		t.Fatal("baseline does not contain the hash of /etc/hosts")
+	}
+
+	err = os.WriteFile(path, []byte(tampered), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Load(path, testKey)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("got %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestLoadWrongKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.json")
+
+	err := testBaseline().Save(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Load(path, []byte("another key"))
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("got %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	b := testBaseline()
+
+	changes := b.Compare([]models.FsObject{
+		{Path: "/etc/hosts", Hash: "a", Modified: 1},
+		{Path: "/etc/passwd", Hash: "x", Modified: 3},
+		{Path: "/etc/shadow", Hash: "c", Modified: 4},
+	})
+
+	want := map[string]string{
+		"/etc/passwd": watcher.KindChange,
+		"/etc/shadow": watcher.KindCreate,
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes, want %d", len(changes), len(want))
+	}
+	for _, c := range changes {
+		if want[c.New.Path] != c.Kind {
+			t.Errorf("got %s for %s, want %s", c.Kind, c.New.Path, want[c.New.Path])
+		}
+	}
+
+	changes = b.Compare([]models.FsObject{{Path: "/etc/hosts", Hash: "a", Modified: 1}})
+	if len(changes) != 1 || changes[0].Kind != watcher.KindDelete || changes[0].New.Path != "/etc/passwd" {
+		t.Errorf("got %+v, want deletion of /etc/passwd", changes)
+	}
+}
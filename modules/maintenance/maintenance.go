@@ -0,0 +1,233 @@
+package maintenance
+
+import (
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultGrace = 30 * time.Second
+
+// defaultLockFiles are locked by dpkg, apt and rpm while they modify the system
+var defaultLockFiles = []string{
+	"/var/lib/dpkg/lock",
+	"/var/lib/dpkg/lock-frontend",
+	"/var/lib/apt/lists/lock",
+	"/var/cache/apt/archives/lock",
+	"/var/lib/rpm/.rpm.lock",
+	"/usr/lib/sysimage/rpm/.rpm.lock",
+}
+
+// defaultProcessNames are the names of package managers as shown in /proc/<pid>/stat, which are cut off after 15 characters
+var defaultProcessNames = []string{
+	"dpkg",
+	"apt",
+	"apt-get",
+	"aptitude",
+	"unattended-upgr",
+	"rpm",
+	"yum",
+	"dnf",
+	"zypper",
+	"packagekitd",
+}
+
+type Config struct {
+	Windows []Window `yaml:"windows"`
+	// DetectPackageManagers treats changes made while a package manager is running as maintenance
+	DetectPackageManagers bool `yaml:"detect_package_managers"`
+	// LockFiles are checked for locks held by package managers. Defaults to the locks of dpkg, apt and rpm
+	LockFiles []string `yaml:"lock_files"`
+	// ProcessNames are compared against the process which caused an event and its ancestors. Defaults to common package managers
+	ProcessNames []string `yaml:"process_names"`
+	// Grace extends a detected package manager run, because events are debounced before they are processed. Defaults to 30s
+	Grace time.Duration `yaml:"grace"`
+	// Batch collects all changes made during maintenance and reports them once it ended or the agent stops.
+	// Sinks receive them as a single change set, the server as individual events. Otherwise changes are reported
+	// immediately and only tagged in the sinks
+	Batch bool `yaml:"batch"`
+}
+
+// Window is a declared maintenance window. It either recurs at Start for Duration on the given weekdays,
+// or is a one-off window from From to To.
+type Window struct {
+	Name string `yaml:"name"`
+	// Weekdays the window recurs on, e.g. tuesday. Every day if empty
+	Weekdays []string `yaml:"weekdays"`
+	// Start is the local time of day in the format 15:04
+	Start    string        `yaml:"start"`
+	Duration time.Duration `yaml:"duration"`
+	From     time.Time     `yaml:"from"`
+	To       time.Time     `yaml:"to"`
+}
+
+type window struct {
+	Window
+	weekdays map[time.Weekday]struct{}
+	hour     int
+	minute   int
+}
+
+// Monitor decides whether changes belong to maintenance. A nil Monitor never reports maintenance.
+type Monitor struct {
+	conf    Config
+	windows []window
+
+	mu       *sync.Mutex
+	lastSeen time.Time
+	lastLock string
+}
+
+func New(conf Config) *Monitor {
+	if len(conf.LockFiles) == 0 {
+		conf.LockFiles = defaultLockFiles
+	}
+	if len(conf.ProcessNames) == 0 {
+		conf.ProcessNames = defaultProcessNames
+	}
+	if conf.Grace <= 0 {
+		conf.Grace = defaultGrace
+	}
+
+	m := Monitor{
+		conf: conf,
+		mu:   &sync.Mutex{},
+	}
+
+	for _, w := range conf.Windows {
+		parsed, err := parseWindow(w)
+		if err != nil {
+			log.Warn().Err(err).Msgf("ignoring maintenance window %q", w.Name)
+			continue
+		}
+
+		m.windows = append(m.windows, parsed)
+	}
+
+	return &m
+}
+
+// Check reports whether a change at t caused by the given processes belongs to maintenance and returns the reason.
+// processes contains the name of the process which caused the change and its ancestors and may be empty.
+func (m *Monitor) Check(t time.Time, processes []string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+
+	for _, w := range m.windows {
+		if w.active(t) {
+			return "window " + w.Name, true
+		}
+	}
+
+	if !m.conf.DetectPackageManagers {
+		return "", false
+	}
+
+	for _, p := range processes {
+		for _, name := range m.conf.ProcessNames {
+			if p == name {
+				return "package manager " + name, true
+			}
+		}
+	}
+
+	m.Poll()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.lastSeen.IsZero() && time.Since(m.lastSeen) < m.conf.Grace {
+		return "lock " + m.lastLock, true
+	}
+
+	return "", false
+}
+
+// Poll checks whether a package manager holds one of the lock files. It should be called regularly,
+// so package manager runs are noticed even if their changes are processed after they finished.
+func (m *Monitor) Poll() {
+	if m == nil || !m.conf.DetectPackageManagers {
+		return
+	}
+
+	for _, path := range m.conf.LockFiles {
+		if !isLocked(path) {
+			continue
+		}
+
+		m.mu.Lock()
+		m.lastSeen = time.Now()
+		m.lastLock = path
+		m.mu.Unlock()
+
+		return
+	}
+}
+
+func parseWindow(w Window) (window, error) {
+	parsed := window{Window: w}
+
+	if !w.From.IsZero() || !w.To.IsZero() {
+		if !w.To.After(w.From) {
+			return parsed, fmt.Errorf("end %s is not after start %s", w.To, w.From)
+		}
+
+		return parsed, nil
+	}
+
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return parsed, fmt.Errorf("invalid start: %w", err)
+	}
+	parsed.hour, parsed.minute = start.Hour(), start.Minute()
+
+	if w.Duration <= 0 {
+		return parsed, fmt.Errorf("invalid duration %s", w.Duration)
+	}
+
+	parsed.weekdays = make(map[time.Weekday]struct{})
+	for _, name := range w.Weekdays {
+		day, err := parseWeekday(name)
+		if err != nil {
+			return parsed, err
+		}
+		parsed.weekdays[day] = struct{}{}
+	}
+
+	return parsed, nil
+}
+
+func parseWeekday(name string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(name, d.String()) || strings.EqualFold(name, d.String()[:3]) {
+			return d, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid weekday %q", name)
+}
+
+func (w window) active(t time.Time) bool {
+	if !w.From.IsZero() || !w.To.IsZero() {
+		return !t.Before(w.From) && t.Before(w.To)
+	}
+
+	// The window may have opened on one of the previous days if it is longer than a day or spans midnight
+	for days := 0; time.Duration(days)*24*time.Hour < w.Duration+24*time.Hour; days++ {
+		day := t.AddDate(0, 0, -days)
+		open := time.Date(day.Year(), day.Month(), day.Day(), w.hour, w.minute, 0, 0, t.Location())
+
+		if _, ok := w.weekdays[open.Weekday()]; len(w.weekdays) > 0 && !ok {
+			continue
+		}
+
+		if !t.Before(open) && t.Before(open.Add(w.Duration)) {
+			return true
+		}
+	}
+
+	return false
+}
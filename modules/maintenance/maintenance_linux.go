@@ -0,0 +1,29 @@
+//go:build linux
+
+package maintenance
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// isLocked reports whether another process holds a POSIX record lock on path, which is how dpkg, apt and rpm lock their databases.
+// The lock is only tested, so running package managers are not disturbed.
+func isLocked(path string) bool {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return false
+	}
+	defer unix.Close(fd)
+
+	lk := unix.Flock_t{
+		Type:   unix.F_WRLCK,
+		Whence: 0,
+	}
+
+	err = unix.FcntlFlock(uintptr(fd), unix.F_GETLK, &lk)
+	if err != nil {
+		return false
+	}
+
+	return lk.Type != unix.F_UNLCK
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package maintenance
+
+// isLocked always reports false, because package manager locks are only detected on Linux.
+func isLocked(path string) bool {
+	return false
+}
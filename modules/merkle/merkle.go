@@ -0,0 +1,211 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/Leantar/fimagent/models"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Node is a node of a Merkle tree which mirrors the directory hierarchy of a watched path.
+// The hash of a directory covers its own attributes and the names and hashes of all of its children,
+// so two trees can be compared by their root hashes and only mismatched subtrees need to be walked.
+type Node struct {
+	Path     string
+	Hash     []byte
+	Children map[string]*Node
+
+	obj *models.FsObject
+}
+
+// Build creates the Merkle tree for root out of all objects which are located below it.
+// Objects outside of root are ignored.
+func Build(root string, objs []models.FsObject) *Node {
+	root = filepath.Clean(root)
+	tree := newNode(root)
+
+	for i := range objs {
+		obj := objs[i]
+
+		rel, err := filepath.Rel(root, obj.Path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+
+		n := tree
+		if rel != "." {
+			for _, name := range strings.Split(rel, string(filepath.Separator)) {
+				child, ok := n.Children[name]
+				if !ok {
+					child = newNode(filepath.Join(n.Path, name))
+					n.Children[name] = child
+				}
+				n = child
+			}
+		}
+
+		n.obj = &obj
+	}
+
+	tree.computeHash()
+
+	return tree
+}
+
+// Root returns the hex encoded root hash of the tree.
+func (n *Node) Root() string {
+	return hex.EncodeToString(n.Hash)
+}
+
+// Object returns the scanned object of this node. Intermediate directories which were not scanned have none.
+func (n *Node) Object() (models.FsObject, bool) {
+	if n.obj == nil {
+		return models.FsObject{}, false
+	}
+
+	return *n.obj, true
+}
+
+// Find returns the node for path or nil if it is not part of the tree.
+func (n *Node) Find(path string) *Node {
+	rel, err := filepath.Rel(n.Path, filepath.Clean(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+
+	if rel == "." {
+		return n
+	}
+
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		child, ok := n.Children[name]
+		if !ok {
+			return nil
+		}
+		n = child
+	}
+
+	return n
+}
+
+// Diff returns the paths of all objects that differ between a and b, sorted by path.
+// Only subtrees whose hashes do not match are walked.
+func Diff(a, b *Node) []string {
+	var paths []string
+	diff(a, b, &paths)
+	sort.Strings(paths)
+
+	return paths
+}
+
+func diff(a, b *Node, paths *[]string) {
+	if a == nil && b == nil {
+		return
+	}
+
+	if a == nil || b == nil {
+		// Subtree only exists on one side. Every object inside it differs
+		n := a
+		if n == nil {
+			n = b
+		}
+		n.walk(func(n *Node) {
+			if n.obj != nil {
+				*paths = append(*paths, n.Path)
+			}
+		})
+		return
+	}
+
+	if bytes.Equal(a.Hash, b.Hash) {
+		return
+	}
+
+	if a.obj != nil && b.obj != nil {
+		if !bytes.Equal(LeafHash(*a.obj), LeafHash(*b.obj)) {
+			*paths = append(*paths, a.Path)
+		}
+	} else if a.obj != nil || b.obj != nil {
+		*paths = append(*paths, a.Path)
+	}
+
+	for name, child := range a.Children {
+		diff(child, b.Children[name], paths)
+	}
+
+	for name, child := range b.Children {
+		if _, ok := a.Children[name]; !ok {
+			diff(nil, child, paths)
+		}
+	}
+}
+
+func (n *Node) walk(fn func(n *Node)) {
+	fn(n)
+	for _, child := range n.Children {
+		child.walk(fn)
+	}
+}
+
+func (n *Node) computeHash() {
+	h := sha256.New()
+	h.Write([]byte{1})
+
+	if n.obj != nil {
+		h.Write(LeafHash(*n.obj))
+	} else {
+		// Intermediate directory without a scanned object
+		h.Write(make([]byte, sha256.Size))
+	}
+
+	names := make([]string, 0, len(n.Children))
+	for name := range n.Children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		child := n.Children[name]
+		child.computeHash()
+
+		_, _ = fmt.Fprintf(h, "%s\x00", name)
+		h.Write(child.Hash)
+	}
+
+	n.Hash = h.Sum(nil)
+}
+
+// LeafHash hashes all attributes of obj that are reported to the server.
+func LeafHash(obj models.FsObject) []byte {
+	h := sha256.New()
+	// Prefix leaves and nodes differently to prevent second preimage attacks
+	h.Write([]byte{0})
+	_, _ = fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d\x00%d\x00%d\x00%d\x00%d\x00%d",
+		obj.Path, obj.Hash, obj.HashMode, obj.Size, obj.Created, obj.Modified, obj.Uid, obj.Gid, obj.Mode)
+	// The access time is left out, because it changes on every read
+	_, _ = fmt.Fprintf(h, "\x00%d\x00%d\x00%d\x00%d",
+		obj.Times.Modify, obj.Times.Change, obj.Times.Birth, obj.Times.Available)
+
+	algs := make([]string, 0, len(obj.Hashes))
+	for alg := range obj.Hashes {
+		algs = append(algs, string(alg))
+	}
+	sort.Strings(algs)
+
+	for _, alg := range algs {
+		_, _ = fmt.Fprintf(h, "\x00%s=%s", alg, obj.Hashes[models.HashAlgorithm(alg)])
+	}
+
+	return h.Sum(nil)
+}
+
+func newNode(path string) *Node {
+	return &Node{
+		Path:     path,
+		Children: make(map[string]*Node),
+	}
+}
@@ -0,0 +1,76 @@
+package merkle
+
+import (
+	"github.com/Leantar/fimagent/models"
+	"reflect"
+	"testing"
+)
+
+func testObjects() []models.FsObject {
+	return []models.FsObject{
+		{Path: "/etc", Mode: 0755},
+		{Path: "/etc/hosts", Hash: "a"},
+		{Path: "/etc/ssh", Mode: 0755},
+		{Path: "/etc/ssh/sshd_config", Hash: "b"},
+		{Path: "/etc/ssh/ssh_config", Hash: "c"},
+		{Path: "/var/log/syslog", Hash: "d"},
+	}
+}
+
+func TestBuildIgnoresObjectsOutsideRoot(t *testing.T) {
+	tree := Build("/etc", testObjects())
+
+	if tree.Find("/var/log/syslog") != nil {
+		t.Error("object outside of root is part of the tree")
+	}
+	if n := tree.Find("/etc/ssh/sshd_config"); n == nil {
+		t.Error("object below root is missing")
+	} else if obj, ok := n.Object(); !ok || obj.Hash != "b" {
+		t.Errorf("got %+v, want hash b", obj)
+	}
+}
+
+func TestRootIsIndependentOfOrder(t *testing.T) {
+	objs := testObjects()
+	reversed := make([]models.FsObject, len(objs))
+	for i, obj := range objs {
+		reversed[len(objs)-1-i] = obj
+	}
+
+	if a, b := Build("/etc", objs).Root(), Build("/etc", reversed).Root(); a != b {
+		t.Errorf("roots differ: %s != %s", a, b)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := testObjects()
+	cur := testObjects()
+	// Change a file, remove one and add another
+	cur[1].Hash = "x"
+	cur = append(cur[:4], cur[5:]...)
+	cur = append(cur, models.FsObject{Path: "/etc/ssh/moduli", Hash: "e"})
+
+	a := Build("/etc", old)
+	b := Build("/etc", cur)
+
+	if a.Root() == b.Root() {
+		t.Fatal("roots of different trees are equal")
+	}
+
+	want := []string{"/etc/hosts", "/etc/ssh/moduli", "/etc/ssh/ssh_config"}
+	if got := Diff(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDiffEqualTrees(t *testing.T) {
+	a := Build("/etc", testObjects())
+	b := Build("/etc", testObjects())
+
+	if a.Root() != b.Root() {
+		t.Errorf("roots of equal trees differ")
+	}
+	if got := Diff(a, b); len(got) != 0 {
+		t.Errorf("got %v, want no differences", got)
+	}
+}
@@ -0,0 +1,270 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// collector writes its samples in the Prometheus text exposition format.
+type collector interface {
+	write(w io.Writer, name string)
+}
+
+type metric struct {
+	name  string
+	help  string
+	typ   string
+	value collector
+}
+
+// Registry holds metrics in registration order.
+type Registry struct {
+	mu      *sync.Mutex
+	metrics []metric
+}
+
+// Default is the registry all metrics created by the New functions are registered in.
+var Default = &Registry{mu: &sync.Mutex{}}
+
+func (r *Registry) register(name, help, typ string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics = append(r.metrics, metric{name: name, help: help, typ: typ, value: c})
+}
+
+// Write writes all metrics in the Prometheus text exposition format.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		m.value.write(w, m.name)
+	}
+}
+
+// Handler serves the metrics of the default registry.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Default.Write(w)
+	})
+}
+
+type Counter struct {
+	v uint64
+}
+
+func NewCounter(name, help string) *Counter {
+	c := &Counter{}
+	Default.register(name, help, "counter", c)
+
+	return c
+}
+
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.v, 1)
+}
+
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.v, n)
+}
+
+func (c *Counter) write(w io.Writer, name string) {
+	_, _ = fmt.Fprintf(w, "%s %d\n", name, atomic.LoadUint64(&c.v))
+}
+
+// CounterVec is a set of counters partitioned by the value of a single label.
+type CounterVec struct {
+	label  string
+	mu     *sync.Mutex
+	values map[string]*Counter
+}
+
+func NewCounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{
+		label:  label,
+		mu:     &sync.Mutex{},
+		values: make(map[string]*Counter),
+	}
+	Default.register(name, help, "counter", c)
+
+	return c
+}
+
+// With returns the counter for the given label value.
+func (c *CounterVec) With(value string) *Counter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counter, ok := c.values[value]
+	if !ok {
+		counter = &Counter{}
+		c.values[value] = counter
+	}
+
+	return counter
+}
+
+func (c *CounterVec) write(w io.Writer, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, value := range sortedKeys(c.values) {
+		_, _ = fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, c.label, escape(value), atomic.LoadUint64(&c.values[value].v))
+	}
+}
+
+type Gauge struct {
+	bits uint64
+}
+
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{}
+	Default.register(name, help, "gauge", g)
+
+	return g
+}
+
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+func (g *Gauge) write(w io.Writer, name string) {
+	_, _ = fmt.Fprintf(w, "%s %s\n", name, formatFloat(math.Float64frombits(atomic.LoadUint64(&g.bits))))
+}
+
+// Histogram counts observations in cumulative buckets.
+type Histogram struct {
+	buckets []float64
+	mu      *sync.Mutex
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		mu:      &sync.Mutex{},
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := newHistogram(buckets)
+	Default.register(name, help, "histogram", h)
+
+	return h
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) write(w io.Writer, name string) {
+	h.writeLabeled(w, name, "")
+}
+
+// writeLabeled writes the samples of h. labels is either empty or a comma terminated list of label pairs.
+func (h *Histogram) writeLabeled(w io.Writer, name, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, upper := range h.buckets {
+		_, _ = fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, formatFloat(upper), h.counts[i])
+	}
+	_, _ = fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+
+	labels = strings.TrimSuffix(labels, ",")
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(h.sum))
+	_, _ = fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// HistogramVec is a set of histograms partitioned by the value of a single label.
+type HistogramVec struct {
+	label   string
+	buckets []float64
+	mu      *sync.Mutex
+	values  map[string]*Histogram
+}
+
+func NewHistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+	h := &HistogramVec{
+		label:   label,
+		buckets: buckets,
+		mu:      &sync.Mutex{},
+		values:  make(map[string]*Histogram),
+	}
+	Default.register(name, help, "histogram", h)
+
+	return h
+}
+
+// With returns the histogram for the given label value.
+func (h *HistogramVec) With(value string) *Histogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist, ok := h.values[value]
+	if !ok {
+		hist = newHistogram(h.buckets)
+		h.values[value] = hist
+	}
+
+	return hist
+}
+
+func (h *HistogramVec) write(w io.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, value := range sortedKeys(h.values) {
+		h.values[value].writeLabeled(w, name, fmt.Sprintf("%s=\"%s\",", h.label, escape(value)))
+	}
+}
+
+// DefBuckets are latency buckets in seconds from 5ms to 10s.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escape escapes a label value as required by the exposition format.
+func escape(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestRegistry() *Registry {
+	return &Registry{mu: &sync.Mutex{}}
+}
+
+func output(r *Registry) string {
+	var b strings.Builder
+	r.Write(&b)
+
+	return b.String()
+}
+
+func TestCounterAndGauge(t *testing.T) {
+	r := newTestRegistry()
+
+	c := &Counter{}
+	r.register("test_total", "A counter.", "counter", c)
+	g := &Gauge{}
+	r.register("test_gauge", "A gauge.", "gauge", g)
+
+	c.Inc()
+	c.Add(2)
+	g.Set(0.5)
+
+	want := `# HELP test_total A counter.
+# TYPE test_total counter
+test_total 3
+# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge 0.5
+`
+	if got := output(r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	r := newTestRegistry()
+
+	h := newHistogram([]float64{0.1, 1})
+	r.register("test_seconds", "A histogram.", "histogram", h)
+
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(3)
+
+	want := `# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 2
+test_seconds_bucket{le="1"} 3
+test_seconds_bucket{le="+Inf"} 4
+test_seconds_sum 3.65
+test_seconds_count 4
+`
+	if got := output(r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestVecLabels(t *testing.T) {
+	r := newTestRegistry()
+
+	c := &CounterVec{label: "kind", mu: &sync.Mutex{}, values: make(map[string]*Counter)}
+	r.register("test_total", "A counter vector.", "counter", c)
+	h := &HistogramVec{label: "method", buckets: []float64{1}, mu: &sync.Mutex{}, values: make(map[string]*Histogram)}
+	r.register("test_seconds", "A histogram vector.", "histogram", h)
+
+	c.With("b").Inc()
+	c.With(`a"\` + "\n").Add(2)
+	h.With("get").Observe(2)
+
+	want := `# HELP test_total A counter vector.
+# TYPE test_total counter
+test_total{kind="a\"\\\n"} 2
+test_total{kind="b"} 1
+# HELP test_seconds A histogram vector.
+# TYPE test_seconds histogram
+test_seconds_bucket{method="get",le="1"} 0
+test_seconds_bucket{method="get",le="+Inf"} 1
+test_seconds_sum{method="get"} 2
+test_seconds_count{method="get"} 1
+`
+	if got := output(r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,66 @@
+package pkgdb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/Leantar/fimagent/models"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const dpkgInfoDir = "/var/lib/dpkg/info"
+
+// loadDpkg reads the md5sums files of all installed dpkg packages into files.
+// Each line of these files contains the MD5 digest and the path relative to / of a file shipped by the package.
+func loadDpkg(files map[string]models.Package) error {
+	paths, err := filepath.Glob(filepath.Join(dpkgInfoDir, "*.md5sums"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		// Names of packages for foreign architectures contain the architecture, e.g. libc6:i386
+		name := strings.TrimSuffix(filepath.Base(path), ".md5sums")
+
+		err := readMd5sums(path, name, files)
+		if errors.Is(err, os.ErrNotExist) {
+			// Package was removed since listing the directory
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readMd5sums(path, name string, files map[string]models.Package) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		digest, file, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			continue
+		}
+
+		files[filepath.Join("/", file)] = models.Package{
+			Name:      name,
+			Algorithm: models.MD5,
+			Digest:    strings.ToLower(digest),
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package pkgdb
+
+import (
+	"fmt"
+	"github.com/Leantar/fimagent/models"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type Verdict string
+
+const (
+	// VerdictUnknown is returned for objects which are not owned by a package or have no expected digest
+	VerdictUnknown Verdict = ""
+	// VerdictMatchesPackage is returned if the content equals the one shipped by the package
+	VerdictMatchesPackage Verdict = "MATCHES_PACKAGE"
+	// VerdictUnexpectedModification is returned if the content differs from the one shipped by the package
+	VerdictUnexpectedModification Verdict = "UNEXPECTED_MODIFICATION"
+)
+
+// sources are the files and directories which change whenever packages are installed, upgraded or removed.
+var sources = []string{
+	dpkgInfoDir,
+	"/var/lib/dpkg/status",
+	"/var/lib/rpm",
+	"/var/lib/rpm/rpmdb.sqlite",
+	"/var/lib/rpm/Packages",
+	"/usr/lib/sysimage/rpm",
+	"/usr/lib/sysimage/rpm/rpmdb.sqlite",
+}
+
+// DB maps paths to the packages which installed them and their expected digests.
+// A nil DB does not own any path.
+type DB struct {
+	files  map[string]models.Package
+	stamps map[string]time.Time
+}
+
+// Load reads the file lists of all installed dpkg and rpm packages.
+// Package managers which are not installed are skipped.
+func Load() (*DB, error) {
+	db := DB{
+		files:  make(map[string]models.Package),
+		stamps: modTimes(),
+	}
+
+	err := loadDpkg(db.files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dpkg database: %w", err)
+	}
+
+	err = loadRpm(db.files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rpm database: %w", err)
+	}
+
+	return &db, nil
+}
+
+// Len returns the number of paths owned by packages.
+func (db *DB) Len() int {
+	if db == nil {
+		return 0
+	}
+
+	return len(db.files)
+}
+
+// Stale reports whether the package databases changed since they were loaded.
+func (db *DB) Stale() bool {
+	if db == nil {
+		return true
+	}
+
+	stamps := modTimes()
+	if len(stamps) != len(db.stamps) {
+		return true
+	}
+
+	for path, t := range stamps {
+		if !db.stamps[path].Equal(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Lookup returns the package which owns path.
+func (db *DB) Lookup(path string) (models.Package, bool) {
+	if db == nil {
+		return models.Package{}, false
+	}
+
+	pkg, ok := db.files[filepath.Clean(path)]
+	return pkg, ok
+}
+
+// Tag sets the package of obj if its path is owned by one and clears it otherwise.
+func (db *DB) Tag(obj *models.FsObject) {
+	obj.Package = nil
+	if pkg, ok := db.Lookup(obj.Path); ok {
+		obj.Package = &pkg
+	}
+}
+
+// Verify compares the content of a tagged regular file against the digest recorded by its package.
+// Files which were hashed with another algorithm, only partially or not at all are only hashed again if rehash is set.
+// Otherwise their verdict is unknown.
+func (db *DB) Verify(obj models.FsObject, rehash bool) (Verdict, error) {
+	if obj.Package == nil || obj.Package.Digest == "" {
+		return VerdictUnknown, nil
+	}
+
+	if obj.Hashes == nil && obj.HashMode == models.HashFull {
+		// Only regular files are hashed
+		return VerdictUnknown, nil
+	}
+
+	digest, ok := obj.Hashes[obj.Package.Algorithm]
+	if !ok || obj.HashMode != models.HashFull {
+		if !rehash {
+			return VerdictUnknown, nil
+		}
+
+		cur, err := models.NewFsObject(obj.Path, models.Options{HashAlgorithms: []models.HashAlgorithm{obj.Package.Algorithm}})
+		if err != nil {
+			return VerdictUnknown, err
+		}
+		digest = cur.Hash
+	}
+
+	if digest == obj.Package.Digest {
+		return VerdictMatchesPackage, nil
+	}
+
+	return VerdictUnexpectedModification, nil
+}
+
+func modTimes() map[string]time.Time {
+	stamps := make(map[string]time.Time)
+	for _, path := range sources {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		stamps[path] = info.ModTime()
+	}
+
+	return stamps
+}
@@ -0,0 +1,53 @@
+package pkgdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/Leantar/fimagent/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	content := []byte("content")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	pkg := &models.Package{Name: "test", Algorithm: models.SHA256, Digest: digest}
+
+	tests := []struct {
+		name   string
+		obj    models.FsObject
+		rehash bool
+		want   Verdict
+	}{
+		{"matching digest", models.FsObject{Hashes: map[models.HashAlgorithm]string{models.SHA256: digest}}, false, VerdictMatchesPackage},
+		{"other digest", models.FsObject{Hashes: map[models.HashAlgorithm]string{models.SHA256: "00"}}, false, VerdictUnexpectedModification},
+		{"not a regular file", models.FsObject{}, true, VerdictUnknown},
+		{"other algorithm", models.FsObject{Hashes: map[models.HashAlgorithm]string{models.Blake3: "00"}}, false, VerdictUnknown},
+		{"other algorithm rehashed", models.FsObject{Hashes: map[models.HashAlgorithm]string{models.Blake3: "00"}}, true, VerdictMatchesPackage},
+		{"skipped", models.FsObject{HashMode: models.HashSkip}, false, VerdictUnknown},
+		{"skipped rehashed", models.FsObject{HashMode: models.HashSkip}, true, VerdictMatchesPackage},
+		{"partial", models.FsObject{HashMode: models.HashHeadTail, Hashes: map[models.HashAlgorithm]string{models.SHA256: "00"}}, false, VerdictUnknown},
+	}
+
+	var db *DB
+	for _, tt := range tests {
+		tt.obj.Path = path
+		tt.obj.Package = pkg
+
+		got, err := db.Verify(tt.obj, tt.rehash)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package pkgdb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/Leantar/fimagent/models"
+	"os/exec"
+	"strings"
+)
+
+// rpmQueryFormat prints one line per file of every installed package.
+// The rpm database is queried through the rpm binary, because it is stored in BerkeleyDB, NDB or SQLite depending on the distribution.
+// Tags with a single value per package are prefixed with = to repeat them on every line of the iterator.
+const rpmQueryFormat = `[%{FILENAMES}\t%{FILEDIGESTS}\t%{=FILEDIGESTALGO}\t%{=NAME}-%{=VERSION}-%{=RELEASE}.%{=ARCH}\n]`
+
+// rpmDigestAlgorithms maps the PGP hash algorithm ids used by rpm to hash algorithms.
+var rpmDigestAlgorithms = map[string]models.HashAlgorithm{
+	"1": models.MD5,
+	"2": models.SHA1,
+	"8": models.SHA256,
+	// Packages built by rpm versions without digest algorithm tag use MD5
+	"(none)": models.MD5,
+}
+
+// loadRpm reads the file lists of all installed rpm packages into files.
+func loadRpm(files map[string]models.Package) error {
+	bin, err := exec.LookPath("rpm")
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(bin, "-qa", "--queryformat", rpmQueryFormat)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 4 || !strings.HasPrefix(fields[0], "/") {
+			continue
+		}
+
+		alg, ok := rpmDigestAlgorithms[fields[2]]
+		if !ok {
+			continue
+		}
+
+		// Directories, symlinks and ghost files have no digest. Their ownership is recorded anyway
+		files[fields[0]] = models.Package{
+			Name:      fields[3],
+			Algorithm: alg,
+			Digest:    fields[1],
+		}
+	}
+	scanErr := scanner.Err()
+
+	err = cmd.Wait()
+	if err != nil {
+		return fmt.Errorf("rpm query failed: %w", err)
+	}
+
+	return scanErr
+}
@@ -0,0 +1,19 @@
+package privileges
+
+type Config struct {
+	// User is the name or uid of the unprivileged user the agent switches to once its watches are in place.
+	// Only CAP_DAC_READ_SEARCH (opening and reading watched files) is kept.
+	// Privileges are not dropped if empty
+	User string `yaml:"user"`
+	// Group is the name or gid of the group to switch to. Defaults to the primary group of User
+	Group string `yaml:"group"`
+	// NoNewPrivs prevents the agent and its children from gaining privileges through execve
+	NoNewPrivs bool `yaml:"no_new_privs"`
+	// Seccomp restricts the agent to the system calls it needs. Other system calls fail with EPERM. Implies NoNewPrivs
+	Seccomp bool `yaml:"seccomp"`
+}
+
+// Enabled reports whether conf restricts the agent in any way.
+func (conf Config) Enabled() bool {
+	return conf.User != "" || conf.NoNewPrivs || conf.Seccomp
+}
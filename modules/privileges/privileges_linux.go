@@ -0,0 +1,226 @@
+//go:build linux
+
+package privileges
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// keptCapabilities are needed to open and read files regardless of their permissions. The fanotify marks are in
+// place before privileges are dropped. Files of other users are read without O_NOATIME, which needs CAP_FOWNER
+var keptCapabilities = []int{
+	unix.CAP_DAC_READ_SEARCH,
+}
+
+// Check reports whether Drop can restrict the agent as described by conf, so a misconfiguration is detected
+// before the initial scan.
+func Check(conf Config) error {
+	if conf.User != "" {
+		if os.Geteuid() != 0 {
+			return errors.New("agent is not running as root")
+		}
+		if _, _, err := lookup(conf.User, conf.Group); err != nil {
+			return fmt.Errorf("failed to look up user %s: %w", conf.User, err)
+		}
+	}
+
+	// Reading keepcaps has no effect, but fails the same way as every other call if all threads can not be changed
+	if err := allThreadsPrctl(unix.PR_GET_KEEPCAPS, 0); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Drop restricts the agent as described by conf. The restrictions apply to all threads of the process.
+func Drop(conf Config) error {
+	if conf.User != "" {
+		if err := switchUser(conf.User, conf.Group); err != nil {
+			return fmt.Errorf("failed to switch to user %s: %w", conf.User, err)
+		}
+	}
+
+	if conf.NoNewPrivs || conf.Seccomp {
+		if err := allThreadsPrctl(unix.PR_SET_NO_NEW_PRIVS, 1); err != nil {
+			return fmt.Errorf("failed to set no_new_privs: %w", err)
+		}
+	}
+
+	if conf.Seccomp {
+		if err := installSeccomp(); err != nil {
+			return fmt.Errorf("failed to install seccomp filter: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// switchUser changes all ids of the process to the given user and group, keeping only keptCapabilities.
+func switchUser(name, group string) error {
+	if os.Geteuid() != 0 {
+		return errors.New("agent is not running as root")
+	}
+
+	uid, gid, err := lookup(name, group)
+	if err != nil {
+		return err
+	}
+
+	// Remove everything else from the bounding set first. This needs CAP_SETPCAP, which is lost with the uid
+	for c := 0; c <= lastCapability(); c++ {
+		if kept(c) {
+			continue
+		}
+
+		err := allThreadsPrctl(unix.PR_CAPBSET_DROP, uintptr(c))
+		if err != nil && !errors.Is(err, unix.EINVAL) {
+			return fmt.Errorf("failed to drop capability %d from bounding set: %w", c, err)
+		}
+	}
+
+	// Without keepcaps all capabilities are cleared once no uid is 0 anymore
+	if err := allThreadsPrctl(unix.PR_SET_KEEPCAPS, 1); err != nil {
+		return fmt.Errorf("failed to keep capabilities: %w", err)
+	}
+
+	// The syscall package changes the ids of all threads
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("failed to set groups: %w", err)
+	}
+	if err := syscall.Setresgid(gid, gid, gid); err != nil {
+		return fmt.Errorf("failed to set gid: %w", err)
+	}
+	if err := syscall.Setresuid(uid, uid, uid); err != nil {
+		return fmt.Errorf("failed to set uid: %w", err)
+	}
+
+	if err := allThreadsPrctl(unix.PR_SET_KEEPCAPS, 0); err != nil {
+		return fmt.Errorf("failed to reset keepcaps: %w", err)
+	}
+
+	// Changing the uid cleared the effective set. Raise the kept capabilities again and drop all others from the permitted set
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	for _, c := range keptCapabilities {
+		data[c/32].Effective |= 1 << (uint(c) % 32)
+		data[c/32].Permitted |= 1 << (uint(c) % 32)
+	}
+
+	err = allThreads(unix.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if err != nil {
+		return fmt.Errorf("failed to set capabilities: %w", err)
+	}
+
+	return verify(uid)
+}
+
+// verify checks that the ids were changed and the kept capabilities are effective.
+func verify(uid int) error {
+	if os.Getuid() != uid || os.Geteuid() != uid {
+		return errors.New("uid was not changed")
+	}
+
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("failed to get capabilities: %w", err)
+	}
+
+	for _, c := range keptCapabilities {
+		if data[c/32].Effective&(1<<(uint(c)%32)) == 0 {
+			return fmt.Errorf("capability %d is not effective", c)
+		}
+	}
+
+	return nil
+}
+
+// lookup resolves the uid of name and the gid of group, which defaults to the primary group of the user.
+// Both can be given as name or numeric id.
+func lookup(name, group string) (int, int, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		u, err = user.LookupId(name)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	gidStr := u.Gid
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			g, err = user.LookupGroupId(group)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+		gidStr = g.Gid
+	}
+
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid uid %s: %w", u.Uid, err)
+	}
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid gid %s: %w", gidStr, err)
+	}
+
+	if uid == 0 {
+		return 0, 0, errors.New("user must not be root")
+	}
+
+	return uid, gid, nil
+}
+
+func kept(c int) bool {
+	for _, k := range keptCapabilities {
+		if k == c {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lastCapability returns the highest capability known to the running kernel.
+func lastCapability() int {
+	data, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return unix.CAP_LAST_CAP
+	}
+
+	last, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return unix.CAP_LAST_CAP
+	}
+
+	return last
+}
+
+func allThreadsPrctl(option int, arg uintptr) error {
+	return allThreads(unix.SYS_PRCTL, uintptr(option), arg, 0)
+}
+
+// allThreads executes a system call on every thread of the process, because capabilities and
+// most prctl settings are per thread.
+func allThreads(trap, a1, a2, a3 uintptr) error {
+	_, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, a3)
+	if errno == syscall.ENOTSUP {
+		return errors.New("the agent must be built with CGO_ENABLED=0 to change privileges of all threads")
+	}
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
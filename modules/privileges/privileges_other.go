@@ -0,0 +1,19 @@
+//go:build !linux
+
+package privileges
+
+import "errors"
+
+// Check fails if conf restricts the agent, because privileges can only be dropped on Linux.
+func Check(conf Config) error {
+	return Drop(conf)
+}
+
+// Drop fails if conf restricts the agent, because privileges can only be dropped on Linux.
+func Drop(conf Config) error {
+	if conf.Enabled() {
+		return errors.New("dropping privileges is only supported on linux")
+	}
+
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build linux && arm64
+
+package privileges
+
+import "golang.org/x/sys/unix"
+
+const auditArch = unix.AUDIT_ARCH_AARCH64
+
+// archSyscalls are system calls with a different name on arm64
+var archSyscalls = []uintptr{
+	unix.SYS_FSTATAT,
+}
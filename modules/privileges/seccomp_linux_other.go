@@ -0,0 +1,8 @@
+//go:build linux && !amd64 && !arm64
+
+package privileges
+
+// auditArch is zero, because the allowlist was only compiled for amd64 and arm64
+const auditArch = 0
+
+var archSyscalls []uintptr
@@ -0,0 +1,71 @@
+//go:build linux
+
+package privileges
+
+import (
+	"golang.org/x/sys/unix"
+	"testing"
+)
+
+// run interprets the subset of BPF used by buildFilter for a system call nr of arch.
+func run(t *testing.T, filter []unix.SockFilter, arch, nr uint32) uint32 {
+	var acc uint32
+
+	for pc := 0; pc < len(filter); pc++ {
+		ins := filter[pc]
+
+		switch ins.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			switch ins.K {
+			case seccompDataNr:
+				acc = nr
+			case seccompDataArch:
+				acc = arch
+			default:
+				t.Fatalf("load of unknown offset %d", ins.K)
+			}
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K:
+			if acc == ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_RET | unix.BPF_K:
+			return ins.K
+		default:
+			t.Fatalf("unknown instruction %#x", ins.Code)
+		}
+	}
+
+	t.Fatal("filter did not return")
+	return 0
+}
+
+func TestBuildFilter(t *testing.T) {
+	const arch = 0xc000003e
+	deny := uint32(seccompRetErrno | uint32(unix.EPERM))
+	syscalls := []uintptr{0, 1, 2, 60, 231}
+
+	filter := buildFilter(arch, syscalls)
+
+	for _, nr := range syscalls {
+		if got := run(t, filter, arch, uint32(nr)); got != seccompRetAllow {
+			t.Errorf("system call %d: got %#x, want allow", nr, got)
+		}
+		if got := run(t, filter, arch+1, uint32(nr)); got != deny {
+			t.Errorf("system call %d of another architecture: got %#x, want EPERM", nr, got)
+		}
+	}
+
+	for _, nr := range []uint32{3, 59, 61, 1000} {
+		if got := run(t, filter, arch, nr); got != deny {
+			t.Errorf("system call %d: got %#x, want EPERM", nr, got)
+		}
+	}
+}
+
+func TestAllowedSyscallsFit(t *testing.T) {
+	if n := len(allowedSyscalls) + len(archSyscalls); n > 255 {
+		t.Errorf("%d allowed system calls exceed the jump offset", n)
+	}
+}
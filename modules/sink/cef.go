@@ -0,0 +1,136 @@
+package sink
+
+import (
+	"strings"
+)
+
+const (
+	cefSeverityDefault    = 3
+	cefSeveritySuspicious = 7
+	cefSeverityCritical   = 10
+)
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+// cefFormatter encodes events in the ArcSight Common Event Format:
+// CEF:0|Vendor|Product|Version|Signature ID|Name|Severity|Extension
+// Attributes without a standard CEF key are sent in labeled custom fields.
+type cefFormatter struct {
+	hostname string
+}
+
+func (f cefFormatter) Format(e Event) ([]byte, error) {
+	severity := cefSeverityDefault
+	if critical(e) {
+		severity = cefSeverityCritical
+	} else if suspicious(e) {
+		severity = cefSeveritySuspicious
+	}
+
+	var b strings.Builder
+	b.WriteString("CEF:0")
+	for _, field := range []string{vendor, product, ProductVersion, e.Kind, eventName(e.Kind)} {
+		b.WriteByte('|')
+		b.WriteString(cefHeaderEscaper.Replace(field))
+	}
+	b.WriteByte('|')
+	b.WriteString(formatInt(int64(severity)))
+	b.WriteByte('|')
+
+	ext := extension{escaper: cefExtensionEscaper, sep: " "}
+	ext.add("rt", formatInt(e.IssuedAt*1000))
+	ext.add("dvchost", f.hostname)
+	ext.add("act", e.Kind)
+	ext.add("filePath", e.FsObject.Path)
+	ext.add("fileHash", e.FsObject.ReportedHash())
+
+	if e.FsObject.Mode != 0 {
+		ext.add("fsize", formatInt(e.FsObject.Size))
+		ext.add("filePermission", formatMode(e.FsObject.Mode))
+		if birth, ok := birthTime(e.FsObject); ok {
+			ext.add("fileCreateTime", formatInt(birth.UnixMilli()))
+		}
+		ext.add("fileModificationTime", formatInt(e.FsObject.Modified*1000))
+		ext.add("cn1Label", "uid")
+		ext.add("cn1", formatInt(int64(e.FsObject.Uid)))
+		ext.add("cn2Label", "gid")
+		ext.add("cn2", formatInt(int64(e.FsObject.Gid)))
+	}
+
+	if old := e.Baseline; old != nil {
+		ext.add("oldFilePath", old.Path)
+		ext.add("oldFileHash", old.ReportedHash())
+		ext.add("oldFileSize", formatInt(old.Size))
+		ext.add("oldFilePermission", formatMode(old.Mode))
+		ext.add("oldFileModificationTime", formatInt(old.Modified*1000))
+	}
+
+	if e.Pid != 0 {
+		ext.add("spid", formatInt(int64(e.Pid)))
+	}
+	if len(e.Processes) > 0 {
+		ext.add("sproc", e.Processes[0])
+		ext.add("cs1Label", "processAncestry")
+		ext.add("cs1", strings.Join(e.Processes, ","))
+	}
+
+	if pkg := e.FsObject.Package; pkg != nil {
+		ext.add("cs2Label", "package")
+		ext.add("cs2", pkg.Name)
+	}
+	if e.PackageVerdict != "" {
+		ext.add("cs3Label", "packageVerdict")
+		ext.add("cs3", string(e.PackageVerdict))
+	}
+	if len(e.Findings) > 0 {
+		ext.add("cs4Label", "findings")
+		ext.add("cs4", joinFindings(e))
+	}
+	if e.Maintenance != "" {
+		ext.add("cs5Label", "maintenance")
+		ext.add("cs5", e.Maintenance)
+	}
+	if len(e.Changes) > 0 {
+		ext.add("cnt", formatInt(int64(len(e.Changes))))
+	}
+
+	b.WriteString(ext.String())
+
+	return []byte(b.String()), nil
+}
+
+// extension builds the key value pairs of CEF and LEEF events. Empty values are left out.
+type extension struct {
+	escaper *strings.Replacer
+	sep     string
+	b       strings.Builder
+}
+
+func (x *extension) add(key, value string) {
+	if value == "" {
+		return
+	}
+
+	if x.b.Len() > 0 {
+		x.b.WriteString(x.sep)
+	}
+	x.b.WriteString(key)
+	x.b.WriteByte('=')
+	x.b.WriteString(x.escaper.Replace(value))
+}
+
+func (x *extension) String() string {
+	return x.b.String()
+}
+
+func joinFindings(e Event) string {
+	findings := make([]string, 0, len(e.Findings))
+	for _, f := range e.Findings {
+		findings = append(findings, string(f))
+	}
+
+	return strings.Join(findings, ",")
+}
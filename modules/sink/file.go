@@ -0,0 +1,114 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+type FileConfig struct {
+	Path string `yaml:"path"`
+	// MaxSize is the size in bytes after which the file is rotated. Zero disables rotation
+	MaxSize int64 `yaml:"max_size"`
+	// MaxBackups is the number of rotated files that are kept as path.1 to path.N. Defaults to 5
+	MaxBackups int `yaml:"max_backups"`
+}
+
+const defaultMaxBackups = 5
+
+// file writes one event per line and rotates the file once it exceeds its maximum size.
+type file struct {
+	conf FileConfig
+	f    Formatter
+
+	mu   *sync.Mutex
+	out  *os.File
+	size int64
+}
+
+func newFile(conf FileConfig, f Formatter) (*file, error) {
+	if conf.Path == "" {
+		return nil, fmt.Errorf("no path given")
+	}
+	if conf.MaxBackups <= 0 {
+		conf.MaxBackups = defaultMaxBackups
+	}
+
+	s := file{
+		conf: conf,
+		f:    f,
+		mu:   &sync.Mutex{},
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (s *file) Write(e Event) error {
+	line, err := s.f.Format(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conf.MaxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.conf.MaxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.out.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write event to %s: %w", s.conf.Path, err)
+	}
+
+	return nil
+}
+
+func (s *file) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.out.Close()
+}
+
+func (s *file) open() error {
+	out, err := os.OpenFile(s.conf.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	info, err := out.Stat()
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+
+	s.out = out
+	s.size = info.Size()
+
+	return nil
+}
+
+// rotate shifts path.N-1 to path.N, moves the current file to path.1 and opens a new one.
+func (s *file) rotate() error {
+	_ = s.out.Close()
+
+	for i := s.conf.MaxBackups - 1; i > 0; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", s.conf.Path, i), fmt.Sprintf("%s.%d", s.conf.Path, i+1))
+	}
+
+	err := os.Rename(s.conf.Path, s.conf.Path+".1")
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rotate %s: %w", s.conf.Path, err)
+	}
+
+	return s.open()
+}
@@ -0,0 +1,106 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Leantar/fimagent/models"
+	"github.com/Leantar/fimagent/modules/pkgdb"
+	"github.com/Leantar/fimagent/modules/watcher"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	FormatJSON = "json"
+	FormatCEF  = "cef"
+	FormatLEEF = "leef"
+)
+
+const (
+	vendor  = "Leantar"
+	product = "fimagent"
+)
+
+// ProductVersion is reported as device or product version by the CEF and LEEF formatters
+var ProductVersion = "dev"
+
+// Formatter encodes an event as a single line without trailing newline.
+type Formatter interface {
+	Format(e Event) ([]byte, error)
+}
+
+// NewFormatter returns the formatter for the given format name. The empty name selects JSON.
+func NewFormatter(name string) (Formatter, error) {
+	switch name {
+	case "", FormatJSON:
+		return jsonFormatter{}, nil
+	case FormatCEF:
+		return cefFormatter{hostname: hostname()}, nil
+	case FormatLEEF:
+		return leefFormatter{hostname: hostname()}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", name)
+	}
+}
+
+type jsonFormatter struct{}
+
+func (jsonFormatter) Format(e Event) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// suspicious reports whether e carries findings which should raise its severity.
+func suspicious(e Event) bool {
+	return len(e.Findings) > 0 || e.PackageVerdict == pkgdb.VerdictUnexpectedModification
+}
+
+// critical reports whether e indicates that the agent itself was attacked.
+func critical(e Event) bool {
+	return e.Kind == KindTamper
+}
+
+// eventName returns a human readable name for the kind of e.
+func eventName(kind string) string {
+	switch kind {
+	case watcher.KindCreate:
+		return "File created"
+	case watcher.KindChange:
+		return "File changed"
+	case watcher.KindDelete:
+		return "File deleted"
+	case KindTamper:
+		return "Agent file modified"
+	case KindAgentStopping:
+		return "Agent stopping"
+	default:
+		return kind
+	}
+}
+
+func formatMode(mode uint32) string {
+	return fmt.Sprintf("%04o", mode&0o7777)
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+
+	return name
+}
+
+// birthTime returns the time obj was created. FsObject.Created is the change time on most platforms,
+// so it is not used.
+func birthTime(obj models.FsObject) (time.Time, bool) {
+	if !obj.Times.Available.Has(models.TimeBirth) {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, obj.Times.Birth), true
+}
+
+func formatInt(i int64) string {
+	return strconv.FormatInt(i, 10)
+}
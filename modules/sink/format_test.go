@@ -0,0 +1,139 @@
+package sink
+
+import (
+	"github.com/Leantar/fimagent/models"
+	"github.com/Leantar/fimagent/modules/analyzer"
+	"strings"
+	"testing"
+)
+
+func TestCEFEscaping(t *testing.T) {
+	e := Event{
+		Kind:      "CHANGE|X",
+		IssuedAt:  1,
+		FsObject:  models.FsObject{Path: `/tmp/a=b\c` + "\nd"},
+		Processes: []string{"sh"},
+	}
+
+	out, err := cefFormatter{hostname: "host"}.Format(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := string(out)
+
+	if strings.Contains(line, "\n") {
+		t.Errorf("line break was not escaped: %q", line)
+	}
+	if !strings.Contains(line, `|CHANGE\|X|`) {
+		t.Errorf("pipe in header was not escaped: %q", line)
+	}
+	if !strings.Contains(line, `filePath=/tmp/a\=b\\c\nd`) {
+		t.Errorf("path was not escaped: %q", line)
+	}
+	if !strings.HasSuffix(line, "sproc=sh cs1Label=processAncestry cs1=sh") {
+		t.Errorf("unexpected extension: %q", line)
+	}
+}
+
+func TestLEEFEscaping(t *testing.T) {
+	e := Event{
+		Kind:     "CREATE",
+		IssuedAt: 1,
+		FsObject: models.FsObject{Path: "/tmp/a\tb\\c=d"},
+	}
+
+	out, err := leefFormatter{hostname: "host"}.Format(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := string(out)
+
+	if !strings.HasPrefix(line, "LEEF:2.0|") || !strings.Contains(line, "|CREATE|x09|") {
+		t.Errorf("unexpected header: %q", line)
+	}
+	// Attributes are delimited by tabs, equal signs need no escaping
+	if !strings.Contains(line, "\tfilePath=/tmp/a\\tb\\\\c=d") {
+		t.Errorf("path was not escaped: %q", line)
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	tests := []struct {
+		e    Event
+		cef  string
+		leef string
+	}{
+		{Event{Kind: "CHANGE"}, "|3|", "sev=3"},
+		{Event{Kind: "CHANGE", Findings: []analyzer.Finding{analyzer.FindingMtimeBackwards}}, "|7|", "sev=7"},
+		{Event{Kind: KindTamper}, "|10|", "sev=10"},
+	}
+
+	for _, tt := range tests {
+		cef, _ := cefFormatter{}.Format(tt.e)
+		if !strings.Contains(string(cef), tt.cef) {
+			t.Errorf("%s: got %q, want severity %s", tt.e.Kind, cef, tt.cef)
+		}
+
+		leef, _ := leefFormatter{}.Format(tt.e)
+		if !strings.Contains(string(leef), tt.leef) {
+			t.Errorf("%s: got %q, want %s", tt.e.Kind, leef, tt.leef)
+		}
+	}
+}
+
+func TestHeaderField(t *testing.T) {
+	tests := []struct {
+		s     string
+		limit int
+		want  string
+	}{
+		{"fimagent", 48, "fimagent"},
+		{"fim agent\n", 48, "fimagent"},
+		{"", 48, "-"},
+		{"abcdef", 3, "abc"},
+	}
+
+	for _, tt := range tests {
+		if got := headerField(tt.s, tt.limit); got != tt.want {
+			t.Errorf("headerField(%q, %d) = %q, want %q", tt.s, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestFileCreateTime(t *testing.T) {
+	changed := models.FsObject{Path: "/etc/hosts", Mode: 0644, Created: 5, Times: models.Timestamps{Change: 5e9, Available: models.TimeChange}}
+	born := changed
+	born.Times.Birth = 3e9
+	born.Times.Available |= models.TimeBirth
+
+	tests := []struct {
+		obj  models.FsObject
+		cef  string
+		leef string
+	}{
+		{changed, "", ""},
+		{born, "fileCreateTime=3000", "fileCreateTime=3"},
+	}
+
+	for _, tt := range tests {
+		e := Event{Kind: "CHANGE", FsObject: tt.obj}
+
+		cef, _ := cefFormatter{}.Format(e)
+		leef, _ := leefFormatter{}.Format(e)
+
+		if tt.cef == "" {
+			// The change time must not be sent as creation time
+			if strings.Contains(string(cef), "fileCreateTime") || strings.Contains(string(leef), "fileCreateTime") {
+				t.Errorf("creation time without birth time: %q, %q", cef, leef)
+			}
+			continue
+		}
+
+		if !strings.Contains(string(cef), tt.cef) {
+			t.Errorf("got %q, want %s", cef, tt.cef)
+		}
+		if !strings.Contains(string(leef), tt.leef) {
+			t.Errorf("got %q, want %s", leef, tt.leef)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package sink
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	leefSeverityDefault    = 3
+	leefSeveritySuspicious = 7
+	leefSeverityCritical   = 10
+
+	// leefTimeFormat is the Go layout matching leefDevTimeFormat
+	leefTimeFormat    = "Jan 02 2006 15:04:05.000 MST"
+	leefDevTimeFormat = "MMM dd yyyy HH:mm:ss.SSS z"
+)
+
+var (
+	leefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ", "\t", " ")
+	// Attributes are delimited by tabs, so tabs inside of values have to be escaped as well
+	leefValueEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+)
+
+// leefFormatter encodes events in the IBM QRadar Log Event Extended Format 2.0 with tab delimited attributes:
+// LEEF:2.0|Vendor|Product|Version|EventID|x09|Attributes
+type leefFormatter struct {
+	hostname string
+}
+
+func (f leefFormatter) Format(e Event) ([]byte, error) {
+	severity := leefSeverityDefault
+	if critical(e) {
+		severity = leefSeverityCritical
+	} else if suspicious(e) {
+		severity = leefSeveritySuspicious
+	}
+
+	var b strings.Builder
+	b.WriteString("LEEF:2.0")
+	for _, field := range []string{vendor, product, ProductVersion, e.Kind, "x09"} {
+		b.WriteByte('|')
+		b.WriteString(leefHeaderEscaper.Replace(field))
+	}
+	b.WriteByte('|')
+
+	attrs := extension{escaper: leefValueEscaper, sep: "\t"}
+	attrs.add("devTime", time.Unix(e.IssuedAt, 0).UTC().Format(leefTimeFormat))
+	attrs.add("devTimeFormat", leefDevTimeFormat)
+	attrs.add("cat", eventName(e.Kind))
+	attrs.add("sev", formatInt(int64(severity)))
+	attrs.add("identHostName", f.hostname)
+	attrs.add("filePath", e.FsObject.Path)
+	attrs.add("fileHash", e.FsObject.ReportedHash())
+
+	if e.FsObject.Mode != 0 {
+		attrs.add("fileSize", formatInt(e.FsObject.Size))
+		attrs.add("filePermission", formatMode(e.FsObject.Mode))
+		if birth, ok := birthTime(e.FsObject); ok {
+			attrs.add("fileCreateTime", formatInt(birth.Unix()))
+		}
+		attrs.add("fileModificationTime", formatInt(e.FsObject.Modified))
+		attrs.add("uid", formatInt(int64(e.FsObject.Uid)))
+		attrs.add("gid", formatInt(int64(e.FsObject.Gid)))
+	}
+
+	if old := e.Baseline; old != nil {
+		attrs.add("oldFileHash", old.ReportedHash())
+		attrs.add("oldFileSize", formatInt(old.Size))
+		attrs.add("oldFilePermission", formatMode(old.Mode))
+		attrs.add("oldUid", formatInt(int64(old.Uid)))
+		attrs.add("oldGid", formatInt(int64(old.Gid)))
+		attrs.add("oldFileModificationTime", formatInt(old.Modified))
+	}
+
+	if e.Pid != 0 {
+		attrs.add("pid", formatInt(int64(e.Pid)))
+	}
+	if len(e.Processes) > 0 {
+		attrs.add("process", e.Processes[0])
+		attrs.add("processAncestry", strings.Join(e.Processes, ","))
+	}
+
+	if pkg := e.FsObject.Package; pkg != nil {
+		attrs.add("package", pkg.Name)
+	}
+	attrs.add("packageVerdict", string(e.PackageVerdict))
+	attrs.add("findings", joinFindings(e))
+	attrs.add("maintenance", e.Maintenance)
+	if len(e.Changes) > 0 {
+		attrs.add("changes", formatInt(int64(len(e.Changes))))
+	}
+
+	b.WriteString(attrs.String())
+
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,180 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Leantar/fimagent/models"
+	"github.com/Leantar/fimagent/modules/analyzer"
+	"github.com/Leantar/fimagent/modules/pkgdb"
+)
+
+const (
+	TypeFile    = "file"
+	TypeSyslog  = "syslog"
+	TypeStdout  = "stdout"
+	TypeWebhook = "webhook"
+)
+
+// KindObject is the kind of events carrying the state of an object which was sent to the server as part of a full scan.
+// These events are only written to sinks with IncludeObjects set
+const KindObject = "OBJECT"
+
+// Events of the following kinds are only written to sinks. The proto can only carry the creation, change and deletion
+// of a file, so the server is not told about them. For the same reason the server never receives the fields of Event
+// besides Kind, IssuedAt and FsObject
+const (
+	// KindTamper is the kind of events reporting a change of the agent's own executable, config or certificates
+	KindTamper = "TAMPER"
+	// KindAgentStopping is written when the agent is stopped, so agents which vanish without it were killed
+	KindAgentStopping = "AGENT_STOPPING"
+	// KindScanTruncated is written if the scan of a watched path reached a limit of its traversal policy.
+	// The status of this path is incomplete
+	KindScanTruncated = "SCAN_TRUNCATED"
+	// KindChangeSet is written once maintenance ended and contains all changes made during it. Its object carries the
+	// reason as path and the start and end of the maintenance as creation and modification time
+	KindChangeSet = "CHANGE_SET"
+)
+
+// Event is an event as it is written to sinks. Besides the fields reported to the server it carries
+// everything the agent knows about the change.
+type Event struct {
+	Kind     string           `json:"kind"`
+	IssuedAt int64            `json:"issued_at"`
+	FsObject models.FsObject  `json:"fs_object"`
+	Baseline *models.FsObject `json:"baseline,omitempty"`
+	// Findings are suspicious metadata changes detected by the analyzer
+	Findings []analyzer.Finding `json:"findings,omitempty"`
+	// PackageVerdict tells whether a file owned by a package still matches the package manifest
+	PackageVerdict pkgdb.Verdict `json:"package_verdict,omitempty"`
+	// Maintenance is the reason why the change is considered part of maintenance
+	Maintenance string `json:"maintenance,omitempty"`
+	// Pid is the process which caused the change and Processes the names of it and its ancestors
+	Pid       int32    `json:"pid,omitempty"`
+	Processes []string `json:"processes,omitempty"`
+	// Changes contains the events of a change set
+	Changes []Event `json:"changes,omitempty"`
+}
+
+// Sink receives a copy of every event.
+type Sink interface {
+	Write(e Event) error
+	Close() error
+}
+
+type Config struct {
+	// Type is one of file, syslog, stdout or webhook
+	Type string `yaml:"type"`
+	// Format is the encoding of events. Can be json (default), cef or leef
+	Format string `yaml:"format"`
+	// Kinds restricts the sink to events of these kinds. All kinds are written if empty
+	Kinds []string `yaml:"kinds"`
+	// IncludeObjects writes every object of full scans as KindObject event
+	IncludeObjects bool          `yaml:"include_objects"`
+	File           FileConfig    `yaml:"file"`
+	Syslog         SyslogConfig  `yaml:"syslog"`
+	Webhook        WebhookConfig `yaml:"webhook"`
+}
+
+// New creates the sink described by conf.
+func New(conf Config) (Sink, error) {
+	f, err := NewFormatter(conf.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Sink
+	switch conf.Type {
+	case TypeFile:
+		s, err = newFile(conf.File, f)
+	case TypeSyslog:
+		s, err = newSyslog(conf.Syslog, f)
+	case TypeStdout:
+		s = newStdout(f)
+	case TypeWebhook:
+		s, err = newWebhook(conf.Webhook, f)
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", conf.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s sink: %w", conf.Type, err)
+	}
+
+	return filter(s, conf.Kinds, conf.IncludeObjects), nil
+}
+
+// Multi writes events to all of its sinks. A nil Multi discards all events.
+type Multi []Sink
+
+// Open creates all sinks in confs. Sinks which were already created are closed if one of them fails.
+func Open(confs []Config) (Multi, error) {
+	var m Multi
+
+	for _, conf := range confs {
+		s, err := New(conf)
+		if err != nil {
+			_ = m.Close()
+			return nil, err
+		}
+
+		m = append(m, s)
+	}
+
+	return m, nil
+}
+
+// Write writes e to every sink, even if some of them fail.
+func (m Multi) Write(e Event) error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Write(e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (m Multi) Close() error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+type kindFilter struct {
+	Sink
+	kinds   map[string]struct{}
+	objects bool
+}
+
+func filter(s Sink, kinds []string, objects bool) Sink {
+	f := kindFilter{
+		Sink:    s,
+		objects: objects,
+	}
+
+	if len(kinds) > 0 {
+		f.kinds = make(map[string]struct{}, len(kinds))
+		for _, k := range kinds {
+			f.kinds[k] = struct{}{}
+		}
+	}
+
+	return &f
+}
+
+func (f *kindFilter) Write(e Event) error {
+	if e.Kind == KindObject && !f.objects {
+		return nil
+	}
+
+	if _, ok := f.kinds[e.Kind]; f.kinds != nil && !ok && e.Kind != KindObject {
+		return nil
+	}
+
+	return f.Sink.Write(e)
+}
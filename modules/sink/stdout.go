@@ -0,0 +1,35 @@
+package sink
+
+import (
+	"os"
+	"sync"
+)
+
+type stdout struct {
+	f  Formatter
+	mu *sync.Mutex
+}
+
+func newStdout(f Formatter) *stdout {
+	return &stdout{
+		f:  f,
+		mu: &sync.Mutex{},
+	}
+}
+
+func (s *stdout) Write(e Event) error {
+	line, err := s.f.Format(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = os.Stdout.Write(append(line, '\n'))
+	return err
+}
+
+func (s *stdout) Close() error {
+	return nil
+}
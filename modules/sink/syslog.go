@@ -0,0 +1,229 @@
+package sink
+
+import (
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	severityCritical = 2
+	severityWarning  = 4
+	severityNotice   = 5
+
+	defaultAppName  = "fimagent"
+	defaultFacility = "daemon"
+	dialTimeout     = 10 * time.Second
+	// writeTimeout keeps a stalled receiver from blocking the agent, which writes events synchronously
+	writeTimeout = 10 * time.Second
+	// redialInterval limits how often an unreachable receiver is dialed, because every attempt blocks the agent
+	redialInterval = 30 * time.Second
+)
+
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"authpriv": 10,
+	"audit":    13,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+type SyslogConfig struct {
+	// Network is one of udp, tcp or unix
+	Network string `yaml:"network"`
+	// Address is host:port for udp and tcp or the socket path for unix, e.g. /dev/log
+	Address string `yaml:"address"`
+	// Facility is the name of the syslog facility. Defaults to daemon
+	Facility string `yaml:"facility"`
+	// AppName is sent as APP-NAME. Defaults to fimagent
+	AppName string `yaml:"app_name"`
+}
+
+// syslog sends events as RFC 5424 messages. Messages sent over TCP are framed using octet counting (RFC 6587).
+type syslog struct {
+	conf     SyslogConfig
+	f        Formatter
+	facility int
+	hostname string
+
+	mu     *sync.Mutex
+	conn   net.Conn
+	dialed time.Time
+}
+
+func newSyslog(conf SyslogConfig, f Formatter) (*syslog, error) {
+	if conf.Facility == "" {
+		conf.Facility = defaultFacility
+	}
+	if conf.AppName == "" {
+		conf.AppName = defaultAppName
+	}
+
+	facility, ok := facilities[conf.Facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown facility %q", conf.Facility)
+	}
+
+	switch conf.Network {
+	case "udp", "tcp", "unix":
+	default:
+		return nil, fmt.Errorf("unsupported network %q", conf.Network)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+
+	s := syslog{
+		conf:     conf,
+		f:        f,
+		facility: facility,
+		hostname: hostname,
+		mu:       &sync.Mutex{},
+	}
+
+	// An unreachable receiver must not keep the agent from starting. It is dialed again on the next writes
+	if err := s.dial(); err != nil {
+		log.Warn().Err(err).Msg("syslog receiver is not reachable")
+	}
+
+	return &s, nil
+}
+
+func (s *syslog) Write(e Event) error {
+	msg, err := s.f.Format(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil && time.Since(s.dialed) >= redialInterval {
+		if err := s.dial(); err != nil {
+			return err
+		}
+	}
+
+	err = s.send(s.message(e, msg))
+	if err == nil || s.conf.Network == "udp" || s.conn == nil {
+		return err
+	}
+
+	// Stream connections break if the receiver restarts. Reconnect once before giving up
+	if err := s.dial(); err != nil {
+		return err
+	}
+
+	return s.send(s.message(e, msg))
+}
+
+func (s *syslog) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+
+	return s.conn.Close()
+}
+
+func (s *syslog) dial() error {
+	if s.conn != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+	s.dialed = time.Now()
+
+	network := s.conf.Network
+	if network == "unix" {
+		// Local syslog daemons usually listen on a datagram socket
+		conn, err := net.DialTimeout("unixgram", s.conf.Address, dialTimeout)
+		if err == nil {
+			s.conn = conn
+			return nil
+		}
+	}
+
+	conn, err := net.DialTimeout(network, s.conf.Address, dialTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog at %s: %w", s.conf.Address, err)
+	}
+	s.conn = conn
+
+	return nil
+}
+
+func (s *syslog) send(msg []byte) error {
+	if s.conn == nil {
+		return fmt.Errorf("not connected to syslog at %s", s.conf.Address)
+	}
+
+	if s.conf.Network == "tcp" {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+
+	if s.conf.Network != "udp" {
+		if err := s.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+			return err
+		}
+	}
+
+	_, err := s.conn.Write(msg)
+	return err
+}
+
+// message builds an RFC 5424 message: <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (s *syslog) message(e Event, msg []byte) []byte {
+	pri := s.facility*8 + severity(e)
+	ts := time.Unix(e.IssuedAt, 0).UTC().Format(time.RFC3339)
+
+	header := fmt.Sprintf("<%d>1 %s %s %s %d %s - ",
+		pri, ts, headerField(s.hostname, 255), headerField(s.conf.AppName, 48), os.Getpid(), headerField(e.Kind, 32))
+
+	return append([]byte(header), msg...)
+}
+
+// severity raises events with suspicious findings to warning and tampering with the agent to critical.
+func severity(e Event) int {
+	if critical(e) {
+		return severityCritical
+	}
+	if suspicious(e) {
+		return severityWarning
+	}
+
+	return severityNotice
+}
+
+// headerField restricts a header field to printable US-ASCII of at most limit characters. Empty fields are replaced by the NILVALUE.
+func headerField(s string, limit int) string {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s) && len(b) < limit; i++ {
+		if s[i] >= 33 && s[i] <= 126 {
+			b = append(b, s[i])
+		}
+	}
+
+	if len(b) == 0 {
+		return "-"
+	}
+
+	return string(b)
+}
@@ -0,0 +1,75 @@
+package sink
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSyslogTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	s, err := newSyslog(SyslogConfig{Network: "tcp", Address: l.Addr().String()}, jsonFormatter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := s.Write(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages are framed by octet counting
+	r := bufio.NewReader(conn)
+	length, err := r.ReadString(' ')
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(length))
+	if err != nil {
+		t.Fatalf("invalid frame length %q", length)
+	}
+
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// <daemon.notice>1 TIMESTAMP HOSTNAME fimagent PID CHANGE - MSG
+	if !strings.HasPrefix(string(msg), "<29>1 ") || !strings.Contains(string(msg), " fimagent ") || !strings.Contains(string(msg), " CHANGE - {") {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
+
+func TestSyslogUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	// The receiver being down must not fail creating the sink
+	s, err := newSyslog(SyslogConfig{Network: "tcp", Address: addr}, jsonFormatter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Write(testEvent()); err == nil {
+		t.Error("writing to an unreachable receiver succeeded")
+	}
+}
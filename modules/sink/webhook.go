@@ -0,0 +1,298 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Leantar/fimagent/modules/baseline"
+	"github.com/Leantar/fimagent/modules/throttle"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultWebhookMethod      = http.MethodPost
+	defaultWebhookContentType = "application/json"
+	defaultSignatureHeader    = "X-Fim-Signature"
+	defaultMaxRetries         = 3
+	defaultBackoff            = time.Second
+	maxWebhookBackoff         = time.Minute
+	defaultQueueSize          = 1000
+	defaultWebhookTimeout     = 10 * time.Second
+)
+
+var (
+	ErrQueueFull     = errors.New("webhook queue is full")
+	ErrWebhookClosed = errors.New("webhook is closed")
+)
+
+type WebhookConfig struct {
+	// URL is a template executed with the event, e.g. https://hooks.example.com/fim/{{.Kind}}?path={{urlquery .FsObject.Path}}
+	URL    string `yaml:"url"`
+	Method string `yaml:"method"`
+	// Body is a template executed with the event. The json function encodes a value as JSON,
+	// e.g. {"text": {{json .FsObject.Path}}}. Defaults to the event encoded in the format of the sink
+	Body        string            `yaml:"body"`
+	ContentType string            `yaml:"content_type"`
+	Headers     map[string]string `yaml:"headers"`
+	// SecretFile contains the key of the HMAC-SHA256 signature of the body, which is sent as sha256=<hex> in SignatureHeader
+	SecretFile      string `yaml:"secret_file"`
+	SignatureHeader string `yaml:"signature_header"`
+	// MaxRetries is the number of retries of failed requests. Defaults to 3, negative values disable retries
+	MaxRetries int `yaml:"max_retries"`
+	// Backoff is the delay before the first retry, which is doubled for every further retry. Defaults to 1s
+	Backoff time.Duration `yaml:"backoff"`
+	// RequestsPerSecond limits the rate of requests. Zero disables the limit
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	// QueueSize is the number of events buffered while requests are pending. Defaults to 1000
+	QueueSize int           `yaml:"queue_size"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+// webhook posts events to an HTTP endpoint. Requests are sent by a background worker,
+// so slow or unavailable endpoints do not block the caller.
+type webhook struct {
+	conf   WebhookConfig
+	f      Formatter
+	url    *template.Template
+	body   *template.Template
+	secret []byte
+	rate   *throttle.Rate
+	client *http.Client
+
+	queue  chan Event
+	done   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mu guards closing the queue, so events are not sent on the closed channel
+	mu     *sync.Mutex
+	closed bool
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+func newWebhook(conf WebhookConfig, f Formatter) (*webhook, error) {
+	if conf.URL == "" {
+		return nil, errors.New("no url given")
+	}
+	if conf.Method == "" {
+		conf.Method = defaultWebhookMethod
+	}
+	if conf.ContentType == "" {
+		conf.ContentType = defaultWebhookContentType
+	}
+	if conf.SignatureHeader == "" {
+		conf.SignatureHeader = defaultSignatureHeader
+	}
+	if conf.MaxRetries < 0 {
+		conf.MaxRetries = 0
+	} else if conf.MaxRetries == 0 {
+		conf.MaxRetries = defaultMaxRetries
+	}
+	if conf.Backoff <= 0 {
+		conf.Backoff = defaultBackoff
+	}
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = defaultQueueSize
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = defaultWebhookTimeout
+	}
+
+	urlTmpl, err := template.New("url").Funcs(templateFuncs).Parse(conf.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url template: %w", err)
+	}
+
+	var bodyTmpl *template.Template
+	if conf.Body != "" {
+		bodyTmpl, err = template.New("body").Funcs(templateFuncs).Parse(conf.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body template: %w", err)
+		}
+	}
+
+	var secret []byte
+	if conf.SecretFile != "" {
+		secret, err = baseline.ReadKey(conf.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhook secret: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	w := webhook{
+		conf:   conf,
+		f:      f,
+		url:    urlTmpl,
+		body:   bodyTmpl,
+		secret: secret,
+		rate:   throttle.NewRate(conf.RequestsPerSecond),
+		client: &http.Client{Timeout: conf.Timeout},
+		queue:  make(chan Event, conf.QueueSize),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+		mu:     &sync.Mutex{},
+	}
+
+	go w.run()
+
+	return &w, nil
+}
+
+// Write queues e. It fails if the queue is full, which happens if the endpoint is unavailable for a long time.
+func (w *webhook) Write(e Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrWebhookClosed
+	}
+
+	select {
+	case w.queue <- e:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close sends the remaining queued events. Pending retries are cancelled once the timeout of a request passed.
+func (w *webhook) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+	case <-time.After(w.conf.Timeout):
+		w.cancel()
+		<-w.done
+	}
+
+	return nil
+}
+
+func (w *webhook) run() {
+	defer close(w.done)
+
+	for e := range w.queue {
+		err := w.send(e)
+		if err != nil {
+			log.Warn().Err(err).Str("path", e.FsObject.Path).Msg("failed to send event to webhook")
+		}
+	}
+}
+
+// send posts e and retries with exponential backoff if the request fails or the endpoint returns a server error.
+func (w *webhook) send(e Event) error {
+	req, err := w.render(e)
+	if err != nil {
+		return err
+	}
+
+	backoff := w.conf.Backoff
+	for attempt := 0; ; attempt++ {
+		w.rate.Wait()
+
+		retry, err := w.post(req)
+		if err == nil || !retry || attempt >= w.conf.MaxRetries {
+			return err
+		}
+
+		log.Debug().Err(err).Msgf("retrying webhook request in %s", backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-w.ctx.Done():
+			return err
+		}
+
+		backoff *= 2
+		if backoff > maxWebhookBackoff {
+			backoff = maxWebhookBackoff
+		}
+	}
+}
+
+type webhookRequest struct {
+	url  string
+	body []byte
+}
+
+func (w *webhook) render(e Event) (webhookRequest, error) {
+	var url bytes.Buffer
+	if err := w.url.Execute(&url, e); err != nil {
+		return webhookRequest{}, fmt.Errorf("failed to render url: %w", err)
+	}
+
+	if w.body == nil {
+		body, err := w.f.Format(e)
+		return webhookRequest{url: url.String(), body: body}, err
+	}
+
+	var body bytes.Buffer
+	if err := w.body.Execute(&body, e); err != nil {
+		return webhookRequest{}, fmt.Errorf("failed to render body: %w", err)
+	}
+
+	return webhookRequest{url: url.String(), body: body.Bytes()}, nil
+}
+
+// post sends a single request. It reports whether a failed request should be retried.
+func (w *webhook) post(r webhookRequest) (bool, error) {
+	req, err := http.NewRequestWithContext(w.ctx, w.conf.Method, r.url, bytes.NewReader(r.body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", w.conf.ContentType)
+	req.Header.Set("User-Agent", product+"/"+ProductVersion)
+	for k, v := range w.conf.Headers {
+		req.Header.Set(k, v)
+	}
+
+	if w.secret != nil {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(r.body)
+		req.Header.Set(w.conf.SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("webhook returned %s", resp.Status)
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+
+	return retry, err
+}
@@ -0,0 +1,195 @@
+package sink
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/Leantar/fimagent/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testEndpoint records the requests it receives and answers them with the given status codes.
+// The last status code is repeated for all further requests.
+type testEndpoint struct {
+	mu       sync.Mutex
+	statuses []int
+	times    []time.Time
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (t *testEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.times = append(t.times, time.Now())
+	t.bodies = append(t.bodies, body)
+	t.headers = append(t.headers, r.Header.Clone())
+
+	status := t.statuses[0]
+	if len(t.statuses) > 1 {
+		t.statuses = t.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (t *testEndpoint) requests() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.times)
+}
+
+func newTestWebhook(t *testing.T, endpoint *testEndpoint, conf WebhookConfig) *webhook {
+	server := httptest.NewServer(endpoint)
+	t.Cleanup(server.Close)
+
+	conf.URL = server.URL + "/{{.Kind}}"
+	w, err := newWebhook(conf, jsonFormatter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func testEvent() Event {
+	return Event{Kind: "CHANGE", IssuedAt: 1, FsObject: models.FsObject{Path: "/etc/hosts"}}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoint := &testEndpoint{statuses: []int{http.StatusOK}}
+	w := newTestWebhook(t, endpoint, WebhookConfig{
+		SecretFile: secret,
+		Body:       `{"path": {{json .FsObject.Path}}}`,
+	})
+
+	if err := w.Write(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+
+	if endpoint.requests() != 1 {
+		t.Fatalf("got %d requests, want 1", endpoint.requests())
+	}
+
+	body := endpoint.bodies[0]
+	if string(body) != `{"path": "/etc/hosts"}` {
+		t.Errorf("got body %s", body)
+	}
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := endpoint.headers[0].Get(defaultSignatureHeader); got != want {
+		t.Errorf("got signature %q, want %q", got, want)
+	}
+}
+
+func TestWebhookRetriesServerErrors(t *testing.T) {
+	endpoint := &testEndpoint{statuses: []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusOK}}
+	w := newTestWebhook(t, endpoint, WebhookConfig{MaxRetries: 3, Backoff: 20 * time.Millisecond})
+
+	if err := w.Write(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+
+	if endpoint.requests() != 3 {
+		t.Fatalf("got %d requests, want 3", endpoint.requests())
+	}
+
+	// The backoff doubles after every retry
+	for i, min := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond} {
+		if d := endpoint.times[i+1].Sub(endpoint.times[i]); d < min {
+			t.Errorf("retry %d was sent after %s, want at least %s", i+1, d, min)
+		}
+	}
+}
+
+func TestWebhookGivesUpAfterMaxRetries(t *testing.T) {
+	endpoint := &testEndpoint{statuses: []int{http.StatusTooManyRequests}}
+	w := newTestWebhook(t, endpoint, WebhookConfig{MaxRetries: 2, Backoff: time.Millisecond})
+
+	if err := w.Write(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+
+	if endpoint.requests() != 3 {
+		t.Errorf("got %d requests, want 3", endpoint.requests())
+	}
+}
+
+func TestWebhookDoesNotRetryClientErrors(t *testing.T) {
+	endpoint := &testEndpoint{statuses: []int{http.StatusBadRequest, http.StatusOK}}
+	w := newTestWebhook(t, endpoint, WebhookConfig{MaxRetries: 3, Backoff: time.Millisecond})
+
+	if err := w.Write(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+
+	if endpoint.requests() != 1 {
+		t.Errorf("got %d requests, want 1", endpoint.requests())
+	}
+}
+
+func TestWebhookRateLimit(t *testing.T) {
+	endpoint := &testEndpoint{statuses: []int{http.StatusOK}}
+	// A burst of 4 requests is allowed, the remaining 2 have to wait for 0.5s
+	w := newTestWebhook(t, endpoint, WebhookConfig{RequestsPerSecond: 4, Timeout: 5 * time.Second})
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := w.Write(testEvent()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = w.Close()
+
+	if endpoint.requests() != 6 {
+		t.Fatalf("got %d requests, want 6", endpoint.requests())
+	}
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("6 requests took %s, want at least 400ms", d)
+	}
+}
+
+func TestWebhookWriteAfterClose(t *testing.T) {
+	endpoint := &testEndpoint{statuses: []int{http.StatusOK}}
+	w := newTestWebhook(t, endpoint, WebhookConfig{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_ = w.Write(testEvent())
+			}
+		}()
+	}
+
+	_ = w.Close()
+	wg.Wait()
+
+	if err := w.Write(testEvent()); !errors.Is(err, ErrWebhookClosed) {
+		t.Errorf("got %v, want %v", err, ErrWebhookClosed)
+	}
+}
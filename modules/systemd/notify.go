@@ -0,0 +1,70 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// States understood by the service manager, see sd_notify(3)
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Status returns the state which shows s as status of the service in systemctl status.
+func Status(s string) string {
+	return "STATUS=" + s
+}
+
+// ExtendTimeout returns the state which extends the start, runtime or stop timeout of the service by d,
+// e.g. while a long scan delays the readiness notification.
+func ExtendTimeout(d time.Duration) string {
+	return "EXTEND_TIMEOUT_USEC=" + strconv.FormatInt(d.Microseconds(), 10)
+}
+
+// Notify sends the given states to the service manager. It does nothing if the agent was not started
+// by systemd with Type=notify.
+func Notify(states ...string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+
+	// Sockets in the abstract namespace are passed with a leading @
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var msg []byte
+	for _, s := range states {
+		msg = append(msg, s...)
+		msg = append(msg, '\n')
+	}
+
+	_, err = conn.Write(msg)
+	return err
+}
+
+// WatchdogInterval returns the watchdog timeout configured with WatchdogSec. It returns zero if the watchdog
+// is disabled or meant for another process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
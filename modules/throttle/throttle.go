@@ -0,0 +1,210 @@
+package throttle
+
+import (
+	"github.com/rs/zerolog/log"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	loadCheckInterval = time.Second
+	maxBackoff        = 30 * time.Second
+)
+
+type Config struct {
+	// BytesPerSecond limits the read bandwidth used for hashing. Zero disables the limit
+	BytesPerSecond int64 `yaml:"bytes_per_second"`
+	// FilesPerSecond limits the number of objects created per second. Zero disables the limit
+	FilesPerSecond float64 `yaml:"files_per_second"`
+	// Nice is applied to the scanning thread if not zero
+	Nice int `yaml:"nice"`
+	// IoClass is applied to the scanning thread. Can be "idle" or "best-effort"
+	IoClass string `yaml:"io_class"`
+	// IoLevel is the priority inside the best-effort class from 0 (highest) to 7 (lowest)
+	IoLevel int `yaml:"io_level"`
+	// MaxLoad pauses scanning while the 1-minute load average is above it. Zero disables the check
+	MaxLoad float64 `yaml:"max_load"`
+}
+
+// Limiter throttles scans. A nil Limiter does not throttle at all.
+type Limiter struct {
+	conf  Config
+	bytes *bucket
+	files *bucket
+
+	mu        *sync.Mutex
+	lastCheck time.Time
+}
+
+func New(conf Config) *Limiter {
+	l := Limiter{
+		conf: conf,
+		mu:   &sync.Mutex{},
+	}
+
+	if conf.BytesPerSecond > 0 {
+		l.bytes = newBucket(float64(conf.BytesPerSecond))
+	}
+	if conf.FilesPerSecond > 0 {
+		l.files = newBucket(conf.FilesPerSecond)
+	}
+
+	return &l
+}
+
+// WaitFile blocks until the next file may be processed.
+func (l *Limiter) WaitFile() {
+	if l == nil {
+		return
+	}
+
+	l.waitForLoad()
+	l.files.wait(1)
+}
+
+// Reader wraps r so that reading from it does not exceed the configured bandwidth.
+func (l *Limiter) Reader(r io.Reader) io.Reader {
+	if l == nil || l.bytes == nil {
+		return r
+	}
+
+	return &reader{r: r, b: l.bytes}
+}
+
+// Run executes fn on a dedicated OS thread with lowered CPU and I/O priority.
+// The thread is discarded afterwards, so the priority of other goroutines is not affected.
+func (l *Limiter) Run(fn func()) {
+	if l == nil || (l.conf.Nice == 0 && l.conf.IoClass == "") {
+		fn()
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		// The goroutine never unlocks the thread. This causes the runtime to terminate it once fn returns
+		runtime.LockOSThread()
+
+		err := lowerThreadPriority(l.conf.Nice, l.conf.IoClass, l.conf.IoLevel)
+		if err != nil {
+			log.Warn().Caller().Err(err).Msg("failed to lower scan priority")
+		}
+
+		fn()
+	}()
+	<-done
+}
+
+// waitForLoad blocks while the system load is above the configured maximum.
+func (l *Limiter) waitForLoad() {
+	if l.conf.MaxLoad <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if time.Since(l.lastCheck) < loadCheckInterval {
+		return
+	}
+
+	backoff := time.Second
+	for {
+		load, err := loadAverage()
+		if err != nil {
+			log.Warn().Caller().Err(err).Msg("failed to read load average")
+			break
+		}
+
+		if load <= l.conf.MaxLoad {
+			break
+		}
+
+		log.Info().Msgf("load %.2f exceeds %.2f. Pausing scan for %s", load, l.conf.MaxLoad, backoff)
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+
+	l.lastCheck = time.Now()
+}
+
+// Rate limits how often an operation is performed. A nil Rate does not limit at all.
+type Rate struct {
+	b *bucket
+}
+
+// NewRate allows perSecond operations per second with a burst of one second. It returns nil if perSecond is not positive.
+func NewRate(perSecond float64) *Rate {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	return &Rate{b: newBucket(perSecond)}
+}
+
+// Wait blocks until the next operation may be performed.
+func (r *Rate) Wait() {
+	if r == nil {
+		return
+	}
+
+	r.b.wait(1)
+}
+
+// bucket is a token bucket that allows a burst of one second worth of tokens.
+// Requests larger than the available tokens are allowed, but the caller has to wait until the debt is paid off.
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+	mu     *sync.Mutex
+}
+
+func newBucket(rate float64) *bucket {
+	return &bucket{
+		rate:   rate,
+		tokens: rate,
+		last:   time.Now(),
+		mu:     &sync.Mutex{},
+	}
+}
+
+func (b *bucket) wait(n float64) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= n
+	tokens := b.tokens
+	b.mu.Unlock()
+
+	if tokens < 0 {
+		time.Sleep(time.Duration(-tokens / b.rate * float64(time.Second)))
+	}
+}
+
+type reader struct {
+	r io.Reader
+	b *bucket
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.b.wait(float64(n))
+
+	return n, err
+}
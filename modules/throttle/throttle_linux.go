@@ -0,0 +1,67 @@
+//go:build linux
+
+package throttle
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+)
+
+// lowerThreadPriority sets the nice value and I/O priority of the calling thread.
+// On Linux both are applied per thread when passing the thread id.
+func lowerThreadPriority(nice int, ioClass string, ioLevel int) error {
+	tid := unix.Gettid()
+
+	if nice != 0 {
+		err := unix.Setpriority(unix.PRIO_PROCESS, tid, nice)
+		if err != nil {
+			return fmt.Errorf("failed to set nice value: %w", err)
+		}
+	}
+
+	var prio int
+	switch ioClass {
+	case "":
+		return nil
+	case "idle":
+		prio = ioprioClassIdle << ioprioClassShift
+	case "best-effort":
+		if ioLevel < 0 || ioLevel > 7 {
+			return fmt.Errorf("invalid io level %d", ioLevel)
+		}
+		prio = ioprioClassBE<<ioprioClassShift | ioLevel
+	default:
+		return fmt.Errorf("unknown io class %q", ioClass)
+	}
+
+	_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio))
+	if errno != 0 {
+		return fmt.Errorf("failed to set io priority: %w", errno)
+	}
+
+	return nil
+}
+
+func loadAverage() (float64, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected content of /proc/loadavg: %q", data)
+	}
+
+	return strconv.ParseFloat(fields[0], 64)
+}
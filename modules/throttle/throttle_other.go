@@ -0,0 +1,17 @@
+//go:build !linux
+
+package throttle
+
+import (
+	"errors"
+)
+
+// lowerThreadPriority is only supported on Linux, where priorities can be set per thread.
+func lowerThreadPriority(_ int, _ string, _ int) error {
+	return errors.New("thread priorities are not supported on this platform")
+}
+
+// loadAverage always reports no load, which disables the load based backoff.
+func loadAverage() (float64, error) {
+	return 0, nil
+}
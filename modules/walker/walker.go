@@ -0,0 +1,313 @@
+package walker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Policy controls how a watched path is traversed. The zero value neither follows symlinks nor skips anything.
+type Policy struct {
+	Path string `yaml:"path"`
+	// FollowSymlinks descends into directories that symlinks point to. Loops are detected and skipped
+	FollowSymlinks bool `yaml:"follow_symlinks"`
+	// OneFileSystem does not descend into directories located on other file systems (like find -xdev)
+	OneFileSystem bool `yaml:"one_file_system"`
+	// SkipFsTypes lists file system types which are skipped entirely, e.g. proc, sysfs or cgroup
+	SkipFsTypes []string `yaml:"skip_fs_types"`
+	// DedupeHardlinks only visits the first path of a file with multiple hard links
+	DedupeHardlinks bool `yaml:"dedupe_hardlinks"`
+	// MaxDepth limits how many directory levels below the path are walked. Zero disables the limit
+	MaxDepth int `yaml:"max_depth"`
+	// MaxObjects limits the number of visited objects. Zero disables the limit
+	MaxObjects int `yaml:"max_objects"`
+	// MaxBytes limits the total size of visited regular files. Zero disables the limit
+	MaxBytes int64 `yaml:"max_bytes"`
+}
+
+// ErrTruncated is returned by Walk if a limit of the policy was reached. All objects up to the limit were visited.
+// Directories below the maximum depth are left out, but their siblings are still walked.
+var ErrTruncated = errors.New("scan truncated")
+
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// mount identifies the file system a directory is located on.
+type mount struct {
+	dev    uint64
+	fsType string
+}
+
+type walker struct {
+	policy  Policy
+	fn      func(path string) error
+	rootDev uint64
+	// dirs contains the directories between the root and the current directory and is used to detect symlink loops.
+	// Directories reached again through another path are walked again, so they are reported below every path
+	dirs map[fileID]struct{}
+	// realDirs is used instead of dirs on platforms without file ids
+	realDirs map[string]struct{}
+	links    map[fileID]struct{}
+	objects  int
+	bytes    int64
+	// truncated is set once a directory below the maximum depth was left out. The walk continues with its siblings
+	truncated error
+}
+
+// PolicyFor returns the policy with the longest path that path is located in.
+func PolicyFor(policies []Policy, path string) Policy {
+	var policy Policy
+	found := false
+
+	for _, p := range policies {
+		if !IsBelow(path, p.Path) {
+			continue
+		}
+
+		if !found || len(p.Path) > len(policy.Path) {
+			policy = p
+			found = true
+		}
+	}
+
+	return policy
+}
+
+// Walk calls fn for root and every object below it according to policy.
+// Errors returned by fn or encountered while walking abort the walk. If a limit is reached, ErrTruncated is returned.
+func Walk(root string, policy Policy, fn func(path string) error) error {
+	root = filepath.Clean(root)
+
+	info, err := os.Lstat(root)
+	if err != nil {
+		return err
+	}
+
+	w := walker{
+		policy:   policy,
+		fn:       fn,
+		dirs:     make(map[fileID]struct{}),
+		realDirs: make(map[string]struct{}),
+		links:    make(map[fileID]struct{}),
+	}
+
+	if id, _, ok := identify(info); ok {
+		w.rootDev = id.dev
+	}
+
+	err = w.walk(root, info, mount{}, 0)
+	if err != nil {
+		return err
+	}
+
+	return w.truncated
+}
+
+// walk visits path. parent is the file system of the parent directory and empty for the root.
+// depth is the number of directory levels between the root and path.
+func (w *walker) walk(path string, info os.FileInfo, parent mount, depth int) error {
+	if info.Mode()&os.ModeSymlink != 0 && w.policy.FollowSymlinks {
+		err := w.visit(path, info)
+		if err != nil {
+			return err
+		}
+
+		target, err := os.Stat(path)
+		if err != nil || !target.IsDir() {
+			// Dangling symlinks and links to files are only reported as link
+			return nil
+		}
+
+		// The link itself was already reported. Only its content is walked
+		return w.walkDir(path, target, parent, depth, false)
+	}
+
+	if info.IsDir() {
+		return w.walkDir(path, info, parent, depth, true)
+	}
+
+	if w.policy.DedupeHardlinks && info.Mode().IsRegular() {
+		if id, nlink, ok := identify(info); ok && nlink > 1 {
+			if _, seen := w.links[id]; seen {
+				return nil
+			}
+			w.links[id] = struct{}{}
+		}
+	}
+
+	return w.visit(path, info)
+}
+
+// walkDir walks the content of a directory. The directory itself is only reported if report is set.
+func (w *walker) walkDir(path string, info os.FileInfo, parent mount, depth int, report bool) error {
+	cur := parent
+
+	id, _, ok := identify(info)
+	if ok {
+		if _, seen := w.dirs[id]; seen {
+			// Directory is its own ancestor, which only happens through a symlink loop
+			return nil
+		}
+		w.dirs[id] = struct{}{}
+		defer delete(w.dirs, id)
+
+		if w.policy.OneFileSystem && id.dev != w.rootDev {
+			// Report the mount point like find -xdev, but do not descend into it
+			if !report {
+				return nil
+			}
+			return w.visit(path, info)
+		}
+	} else if w.policy.FollowSymlinks {
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return err
+		}
+
+		if _, seen := w.realDirs[real]; seen {
+			return nil
+		}
+		w.realDirs[real] = struct{}{}
+		defer delete(w.realDirs, real)
+	}
+
+	// The file system type only has to be determined at mount points
+	if len(w.policy.SkipFsTypes) > 0 && (!ok || parent.fsType == "" || id.dev != parent.dev) {
+		fsType, err := fileSystemType(path)
+		if err != nil {
+			return fmt.Errorf("failed to get file system type of %s: %w", path, err)
+		}
+
+		if w.skipFsType(fsType) {
+			return nil
+		}
+
+		cur = mount{dev: id.dev, fsType: fsType}
+	}
+
+	if report {
+		err := w.visit(path, info)
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.policy.MaxDepth > 0 && depth >= w.policy.MaxDepth {
+		if w.truncated == nil && !isEmptyDir(path) {
+			w.truncated = fmt.Errorf("%w: reached maximum depth of %d below %s", ErrTruncated, w.policy.MaxDepth, path)
+		}
+		return nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	for _, entry := range entries {
+		child := filepath.Join(path, entry.Name())
+
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			// Removed since reading the directory
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = w.walk(child, info, cur, depth+1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// visit calls fn for path after checking the object and size limits.
+func (w *walker) visit(path string, info os.FileInfo) error {
+	if w.policy.MaxObjects > 0 && w.objects >= w.policy.MaxObjects {
+		return fmt.Errorf("%w: reached maximum of %d objects", ErrTruncated, w.policy.MaxObjects)
+	}
+
+	if info.Mode().IsRegular() {
+		if w.policy.MaxBytes > 0 && w.bytes+info.Size() > w.policy.MaxBytes {
+			return fmt.Errorf("%w: reached maximum of %d bytes", ErrTruncated, w.policy.MaxBytes)
+		}
+		w.bytes += info.Size()
+	}
+	w.objects++
+
+	return w.fn(path)
+}
+
+// isEmptyDir reports whether path has no entries. Directories which can not be read are treated as not empty.
+func isEmptyDir(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	_, err = f.Readdirnames(1)
+	return errors.Is(err, io.EOF)
+}
+
+func (w *walker) skipFsType(fsType string) bool {
+	for _, t := range w.policy.SkipFsTypes {
+		if t == fsType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsBelow reports whether path is dir or located inside of it.
+func IsBelow(path, dir string) bool {
+	path = filepath.Clean(path)
+	dir = filepath.Clean(dir)
+	if path == dir {
+		return true
+	}
+
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// Roots returns paths without the ones located inside of another path, so overlapping watched paths are only
+// walked once. The order of the remaining paths is kept.
+func Roots(paths []string) []string {
+	var roots []string
+
+	for i, path := range paths {
+		nested := false
+		for j, other := range paths {
+			if i == j || !IsBelow(path, other) {
+				continue
+			}
+
+			// Of equal paths only the first one is kept
+			if filepath.Clean(path) != filepath.Clean(other) || j < i {
+				nested = true
+				break
+			}
+		}
+
+		if !nested {
+			roots = append(roots, path)
+		}
+	}
+
+	return roots
+}
@@ -0,0 +1,29 @@
+//go:build darwin
+
+package walker
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"syscall"
+)
+
+func identify(info os.FileInfo) (fileID, uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, 0, false
+	}
+
+	return fileID{dev: uint64(stat.Dev), ino: stat.Ino}, uint64(stat.Nlink), true
+}
+
+func fileSystemType(path string) (string, error) {
+	var stat unix.Statfs_t
+
+	err := unix.Statfs(path, &stat)
+	if err != nil {
+		return "", err
+	}
+
+	return unix.ByteSliceToString(stat.Fstypename[:]), nil
+}
@@ -0,0 +1,61 @@
+//go:build linux
+
+package walker
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"syscall"
+)
+
+// fsTypes maps the magic numbers returned by statfs to the names used in /proc/mounts
+var fsTypes = map[int64]string{
+	0x0187:     "autofs",
+	0x9123683e: "btrfs",
+	0xcafe4a11: "bpf",
+	0x27e0eb:   "cgroup",
+	0x63677270: "cgroup2",
+	0x62656570: "configfs",
+	0x64626720: "debugfs",
+	0x1cd1:     "devpts",
+	0xef53:     "ext4",
+	0x65735546: "fuse",
+	0x65735543: "fusectl",
+	0x958458f6: "hugetlbfs",
+	0x19800202: "mqueue",
+	0x6969:     "nfs",
+	0x794c7630: "overlay",
+	0x9fa0:     "proc",
+	0x6165676c: "pstore",
+	0x73636673: "securityfs",
+	0x62656572: "sysfs",
+	0x01021994: "tmpfs",
+	0x74726163: "tracefs",
+	0x58465342: "xfs",
+	0x2fc12fc1: "zfs",
+}
+
+func identify(info os.FileInfo) (fileID, uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, 0, false
+	}
+
+	return fileID{dev: stat.Dev, ino: stat.Ino}, uint64(stat.Nlink), true
+}
+
+func fileSystemType(path string) (string, error) {
+	var stat unix.Statfs_t
+
+	err := unix.Statfs(path, &stat)
+	if err != nil {
+		return "", err
+	}
+
+	if name, ok := fsTypes[int64(stat.Type)]; ok {
+		return name, nil
+	}
+
+	return fmt.Sprintf("0x%x", stat.Type), nil
+}
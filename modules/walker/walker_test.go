@@ -0,0 +1,155 @@
+package walker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testTree creates a/b/c/file, a/x and y below a temporary directory. All files are 10 bytes large.
+func testTree(t *testing.T) string {
+	root := t.TempDir()
+
+	err := os.MkdirAll(filepath.Join(root, "a", "b", "c"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a/b/c/file", "a/x", "y"} {
+		err := os.WriteFile(filepath.Join(root, name), []byte("0123456789"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+func walkTree(root string, policy Policy) ([]string, error) {
+	var paths []string
+	err := Walk(root, policy, func(path string) error {
+		rel, _ := filepath.Rel(root, path)
+		paths = append(paths, filepath.ToSlash(rel))
+		return nil
+	})
+
+	return paths, err
+}
+
+func TestWalk(t *testing.T) {
+	root := testTree(t)
+
+	paths, err := walkTree(root, Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{".", "a", "a/b", "a/b/c", "a/b/c/file", "a/x", "y"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got %v, want %v", paths, want)
+	}
+}
+
+func TestWalkFollowSymlinks(t *testing.T) {
+	root := t.TempDir()
+
+	err := os.MkdirAll(filepath.Join(root, "b"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "b", "f"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// a sorts before the directory it points to and up creates a loop
+	if err := os.Symlink("b", filepath.Join(root, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..", filepath.Join(root, "b", "up")); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := walkTree(root, Policy{FollowSymlinks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{".", "a", "a/f", "a/up", "b", "b/f", "b/up"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got %v, want %v", paths, want)
+	}
+}
+
+func TestWalkMaxDepth(t *testing.T) {
+	root := testTree(t)
+
+	paths, err := walkTree(root, Policy{MaxDepth: 2})
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("got %v, want %v", err, ErrTruncated)
+	}
+
+	// Siblings of the cut off directory are still walked
+	want := []string{".", "a", "a/b", "a/x", "y"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got %v, want %v", paths, want)
+	}
+}
+
+func TestWalkMaxDepthEmptyDir(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := walkTree(root, Policy{MaxDepth: 1})
+	if err != nil {
+		t.Errorf("got %v, want no error for an empty directory at the maximum depth", err)
+	}
+}
+
+func TestWalkMaxObjects(t *testing.T) {
+	root := testTree(t)
+
+	paths, err := walkTree(root, Policy{MaxObjects: 3})
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("got %v, want %v", err, ErrTruncated)
+	}
+	if len(paths) != 3 {
+		t.Errorf("got %d objects, want 3", len(paths))
+	}
+}
+
+func TestWalkMaxBytes(t *testing.T) {
+	root := testTree(t)
+
+	paths, err := walkTree(root, Policy{MaxBytes: 25})
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("got %v, want %v", err, ErrTruncated)
+	}
+
+	// Only two of the three files fit
+	want := []string{".", "a", "a/b", "a/b/c", "a/b/c/file", "a/x"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got %v, want %v", paths, want)
+	}
+}
+
+func TestRoots(t *testing.T) {
+	tests := []struct {
+		paths []string
+		want  []string
+	}{
+		{[]string{"/etc", "/etc/ssh"}, []string{"/etc"}},
+		{[]string{"/etc/ssh", "/etc"}, []string{"/etc"}},
+		{[]string{"/etc", "/etc/"}, []string{"/etc"}},
+		{[]string{"/etc", "/etcetera", "/usr/bin"}, []string{"/etc", "/etcetera", "/usr/bin"}},
+		{nil, nil},
+	}
+
+	for _, tt := range tests {
+		if got := Roots(tt.paths); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Roots(%v) = %v, want %v", tt.paths, got, tt.want)
+		}
+	}
+}
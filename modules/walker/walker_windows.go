@@ -0,0 +1,17 @@
+//go:build windows
+
+package walker
+
+import (
+	"os"
+)
+
+// identify is not supported on Windows. Hard links are not deduplicated and loops are not detected.
+func identify(_ os.FileInfo) (fileID, uint64, bool) {
+	return fileID{}, 0, false
+}
+
+// fileSystemType is not supported on Windows.
+func fileSystemType(_ string) (string, error) {
+	return "", nil
+}
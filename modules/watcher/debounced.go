@@ -1,12 +1,20 @@
 package watcher
 
 import (
+	"github.com/Leantar/fimagent/modules/metrics"
 	"github.com/rs/zerolog/log"
 	"path/filepath"
 	"sync"
 	"time"
 )
 
+var (
+	eventsReceived  = metrics.NewCounterVec("fim_watcher_events_total", "File system events received from the watcher backend.", "kind")
+	eventsDebounced = metrics.NewCounter("fim_watcher_events_debounced_total", "Events merged into a pending event of the same path.")
+	eventsDropped   = metrics.NewCounter("fim_watcher_events_dropped_total", "Events which could not be processed or were superseded by the deletion of a parent directory.")
+	pendingEvents   = metrics.NewGauge("fim_watcher_pending_events", "Events waiting in the debounce map.")
+)
+
 type DebouncedWatcher struct {
 	Events chan Event
 	w      *Watcher
@@ -33,6 +41,14 @@ func (d *DebouncedWatcher) AddRecursiveWatch(p string) error {
 	return d.w.AddRecursiveWatch(p)
 }
 
+// Pending returns the number of events waiting to be debounced.
+func (d *DebouncedWatcher) Pending() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.events)
+}
+
 func (d *DebouncedWatcher) Close() error {
 	d.done <- struct{}{}
 
@@ -43,6 +59,8 @@ func (d *DebouncedWatcher) receiveEvents() {
 	for {
 		select {
 		case event := <-d.w.Events:
+			eventsReceived.With(event.Kind()).Inc()
+
 			if event.Kind() == KindDelete {
 				d.removeSuperseded(event)
 			}
@@ -51,10 +69,17 @@ func (d *DebouncedWatcher) receiveEvents() {
 
 			if e, ok := d.events[event.Path]; ok {
 				// An event for this path already exists. We have to debounce it
-				d.events[event.Path] = debounceEvent(e, event)
+				eventsDebounced.Inc()
+				e = debounceEvent(e, event)
+				if event.Pid != 0 {
+					e.Pid = event.Pid
+					e.Processes = event.Processes
+				}
+				d.events[event.Path] = e
 			} else {
 				d.events[event.Path] = event
 			}
+			pendingEvents.Set(float64(len(d.events)))
 
 			d.mu.Unlock()
 
@@ -70,19 +95,25 @@ func (d *DebouncedWatcher) sendEvents() {
 	for {
 		select {
 		case <-t.C:
+			var due []Event
+
 			d.mu.Lock()
 
 			for _, e := range d.events {
-				// Forward event to user
-				due := e.LastModified.Add(10 * time.Second)
-				if time.Now().After(due) {
-					log.Info().Msgf("firing event for %s", e.Path)
-					d.Events <- e
+				if time.Now().After(e.LastModified.Add(10 * time.Second)) {
+					due = append(due, e)
 					delete(d.events, e.Path)
 				}
 			}
+			pendingEvents.Set(float64(len(d.events)))
 
 			d.mu.Unlock()
+
+			// Forward events to user without holding the lock, the receiver may call Pending meanwhile
+			for _, e := range due {
+				log.Info().Msgf("firing event for %s", e.Path)
+				d.Events <- e
+			}
 		case <-d.done:
 			return
 		}
@@ -101,6 +132,7 @@ func (d *DebouncedWatcher) removeSuperseded(event Event) {
 			// Discard other events if they occurred in this event folder
 			if event.Path == path {
 				delete(d.events, e.Path)
+				eventsDropped.Inc()
 			}
 
 			lastPath = path
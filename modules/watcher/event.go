@@ -15,4 +15,9 @@ type Event struct {
 	Mask         uint64
 	Created      time.Time
 	LastModified time.Time
+	// Pid is the process which caused the last debounced event. It is zero if the backend does not report it
+	Pid int32
+	// Processes contains the names of the process which caused the event and its ancestors, starting with the process itself.
+	// It is resolved when the event is received, because the process may have exited once the debounced event fires
+	Processes []string
 }
@@ -2,6 +2,10 @@
 
 package watcher
 
+import (
+	"golang.org/x/sys/unix"
+)
+
 func (e Event) Kind() string {
 	masks := map[uint64]string{
 		unix.FAN_CREATE:     KindCreate,
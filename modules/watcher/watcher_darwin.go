@@ -10,6 +10,9 @@ import (
 	"time"
 )
 
+// Backend is the name of the kernel interface used to receive file system events
+const Backend = "fsevents"
+
 type Watcher struct {
 	Events   chan Event
 	watchers []*fsevents.EventStream
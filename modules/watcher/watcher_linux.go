@@ -12,6 +12,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -38,6 +39,9 @@ const (
 		unix.O_RDONLY
 )
 
+// maxAncestors limits how many parents of the process causing an event are resolved
+const maxAncestors = 8
+
 type fanotifyEventInfoHeader struct {
 	InfoType uint8
 	Pad      uint8
@@ -49,12 +53,18 @@ type fanotifyEventInfoFid struct {
 	FSID uint64
 }
 
+// Backend is the name of the kernel interface used to receive file system events
+const Backend = "fanotify"
+
 type Watcher struct {
 	Events  chan Event
 	fd      int
 	mountFd int
 	watches map[string]struct{}
 	mu      *sync.Mutex
+	// lastPid and lastProcesses cache the attribution of the previous event, because processes usually cause many events in a row
+	lastPid       int32
+	lastProcesses []string
 }
 
 func New() *Watcher {
@@ -168,6 +178,7 @@ func (w *Watcher) readEvents() {
 			err = binary.Read(rd, binary.LittleEndian, &fid)
 			if err != nil {
 				log.Error().Caller().Err(err).Msg("failed to read event fid")
+				eventsDropped.Inc()
 				offset, _ = rd.Seek(offset+int64(event.Event_len), io.SeekStart)
 				continue
 			}
@@ -185,6 +196,7 @@ func (w *Watcher) readEvents() {
 			err = binary.Read(rd, binary.LittleEndian, &fhInfo)
 			if err != nil {
 				log.Error().Caller().Err(err).Msg("failed to read file handle info")
+				eventsDropped.Inc()
 				offset, _ = rd.Seek(offset+int64(event.Event_len), io.SeekStart)
 				continue
 			}
@@ -195,6 +207,7 @@ func (w *Watcher) readEvents() {
 			err = binary.Read(rd, binary.LittleEndian, &fileHandle)
 			if err != nil {
 				log.Error().Caller().Err(err).Msg("failed to read file handle")
+				eventsDropped.Inc()
 				offset, _ = rd.Seek(offset+int64(event.Event_len), io.SeekStart)
 				continue
 			}
@@ -208,6 +221,7 @@ func (w *Watcher) readEvents() {
 					// It can be safely ignored, because the more important underlying folder event does not produce such an error
 					log.Error().Caller().Err(err).Msg("failed to open file handle")
 				}
+				eventsDropped.Inc()
 				offset, _ = rd.Seek(offset+int64(event.Event_len), io.SeekStart)
 				continue
 			}
@@ -216,6 +230,7 @@ func (w *Watcher) readEvents() {
 			dir, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd))
 			if err != nil {
 				log.Error().Caller().Err(err).Msg("failed to read symlink")
+				eventsDropped.Inc()
 				offset, _ = rd.Seek(offset+int64(event.Event_len), io.SeekStart)
 				continue
 			}
@@ -249,8 +264,60 @@ func (w *Watcher) readEvents() {
 					Mask:         event.Mask,
 					Created:      t,
 					LastModified: t,
+					Pid:          event.Pid,
+					Processes:    w.processes(event.Pid),
 				}
 			}
 		}
 	}
 }
+
+// processes returns the names of pid and its ancestors. The result of the previous call is reused for the same pid.
+func (w *Watcher) processes(pid int32) []string {
+	if pid <= 0 {
+		return nil
+	}
+
+	if pid != w.lastPid {
+		w.lastPid = pid
+		w.lastProcesses = processAncestry(pid)
+	}
+
+	return w.lastProcesses
+}
+
+// processAncestry reads the names of pid and up to maxAncestors of its parents from /proc.
+func processAncestry(pid int32) []string {
+	var names []string
+
+	for i := 0; i <= maxAncestors && pid > 1; i++ {
+		data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			// Process already exited
+			break
+		}
+
+		// The name is enclosed in parentheses and may contain spaces or parentheses itself
+		stat := string(data)
+		start := strings.IndexByte(stat, '(')
+		end := strings.LastIndexByte(stat, ')')
+		if start < 0 || end < start {
+			break
+		}
+		names = append(names, stat[start+1:end])
+
+		// The fields after the name are the state and the parent pid
+		fields := strings.Fields(stat[end+1:])
+		if len(fields) < 2 {
+			break
+		}
+
+		ppid, err := strconv.ParseInt(fields[1], 10, 32)
+		if err != nil {
+			break
+		}
+		pid = int32(ppid)
+	}
+
+	return names
+}
@@ -11,6 +11,9 @@ import (
 	"time"
 )
 
+// Backend is the name of the kernel interface used to receive file system events
+const Backend = "fsnotify"
+
 type Watcher struct {
 	Events  chan Event
 	watcher *fsnotify.Watcher
@@ -61,7 +64,8 @@ func (w *Watcher) AddRecursiveWatch(p string) error {
 			return err
 		}
 
-		if entry.IsDir() {
+		// Single files are watched directly
+		if entry.IsDir() || path == p {
 			err := w.watcher.Add(path)
 			if err != nil {
 				return err